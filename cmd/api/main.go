@@ -11,9 +11,14 @@ import (
 
 	"github.com/joho/godotenv"
 	"github.com/pardnchiu/go-faas/internal"
+	"github.com/pardnchiu/go-faas/internal/auth"
 	"github.com/pardnchiu/go-faas/internal/checker"
 	"github.com/pardnchiu/go-faas/internal/database"
+	"github.com/pardnchiu/go-faas/internal/handler"
+	"github.com/pardnchiu/go-faas/internal/queue"
 	"github.com/pardnchiu/go-faas/internal/sandbox"
+	"github.com/pardnchiu/go-faas/internal/scheduler"
+	"github.com/pardnchiu/go-faas/internal/stream"
 )
 
 func init() {
@@ -35,9 +40,27 @@ func main() {
 	}
 	defer database.Close()
 
+	if err := auth.Init(); err != nil {
+		slog.Error("failed to initialize auth", "error", err)
+		os.Exit(1)
+	}
+
+	// * scopes left by dead owners are stopped, owner matched by pid and start time
 	if err := sandbox.NewSlice(); err != nil {
 		slog.Warn("failed to initialize slice", "error", err)
 	}
+	sandbox.ReapScopes()
+
+	// * async queue and stream buffer build on the redis connection
+	if store, ok := database.DB.(*database.RedisStore); ok {
+		queue.Start(store.RDB, handler.RunJob)
+		stream.Start(store.RDB)
+	} else {
+		slog.Warn("async queue disabled, requires redis store")
+		stream.Start(nil)
+	}
+
+	scheduler.Start(handler.RunSchedule)
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
@@ -62,4 +85,7 @@ func main() {
 		slog.Error("Server shutdown failed", "error", err)
 		os.Exit(1)
 	}
+
+	scheduler.Stop()
+	queue.Stop()
 }
@@ -0,0 +1,217 @@
+package admission
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pardnchiu/go-faas/internal/utils"
+)
+
+var (
+	ErrQueueFull   = errors.New("admission queue is full")
+	ErrWaitTimeout = errors.New("timed out waiting for a sandbox slot")
+
+	mu      sync.Mutex
+	running = map[string]int{}
+	waiters []*waiter
+	stats   Stats
+	// * moving average of slot hold time, basis of Retry-After
+	holdAvg time.Duration
+
+	configOnce     sync.Once
+	maxConcurrency int
+	maxQueue       int
+	maxWait        time.Duration
+)
+
+const globalKey = "*"
+
+// * one sandbox slot, limits of 0 mean unlimited
+type Request struct {
+	Language      string
+	Function      string
+	FunctionLimit int
+}
+
+type Stats struct {
+	Running        int   `json:"running"`
+	Queued         int   `json:"queued"`
+	MaxConcurrency int   `json:"max_concurrency"`
+	MaxQueue       int   `json:"max_queue"`
+	Admitted       int64 `json:"admitted"`
+	Rejected       int64 `json:"rejected"`
+	WaitTotal      int64 `json:"wait_total_ms"`
+	WaitMax        int64 `json:"wait_max_ms"`
+}
+
+type waiter struct {
+	req      Request
+	ready    chan struct{}
+	admitted bool
+}
+
+func load() {
+	configOnce.Do(func() {
+		maxConcurrency = utils.GetWithDefaultInt("MAX_CONCURRENCY", 16)
+		maxQueue = utils.GetWithDefaultInt("MAX_QUEUE", 64)
+		maxWait = time.Duration(utils.GetWithDefaultInt("MAX_QUEUE_WAIT_SECONDS", 10)) * time.Second
+	})
+}
+
+func languageLimit(lang string) int {
+	return utils.GetWithDefaultInt("MAX_CONCURRENCY_"+strings.ToUpper(lang), 0)
+}
+
+// * wait in FIFO order for a slot, returned func frees it
+func Acquire(ctx context.Context, req Request) (func(), error) {
+	load()
+	begin := time.Now()
+
+	mu.Lock()
+	w := &waiter{req: req, ready: make(chan struct{})}
+	waiters = append(waiters, w)
+	dispatch()
+	if w.admitted {
+		mu.Unlock()
+		return releaser(req, begin), nil
+	}
+	if len(waiters) > maxQueue {
+		remove(w)
+		stats.Rejected++
+		mu.Unlock()
+		return nil, ErrQueueFull
+	}
+	mu.Unlock()
+
+	timer := time.NewTimer(maxWait)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case <-w.ready:
+		return releaser(req, begin), nil
+	case <-timer.C:
+		err = ErrWaitTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	// * admitted while giving up, hand slot to next waiter
+	if w.admitted {
+		free(req)
+		dispatch()
+	} else {
+		remove(w)
+	}
+	stats.Rejected++
+	return nil, err
+}
+
+// * seconds until a slot is likely free
+func RetryAfter() int {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return max(int(math.Ceil(holdAvg.Seconds())), 1)
+}
+
+func Snapshot() Stats {
+	load()
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	s := stats
+	s.Running = running[globalKey]
+	s.Queued = len(waiters)
+	s.MaxConcurrency = maxConcurrency
+	s.MaxQueue = maxQueue
+	return s
+}
+
+func releaser(req Request, begin time.Time) func() {
+	wait := time.Since(begin)
+
+	mu.Lock()
+	stats.Admitted++
+	stats.WaitTotal += wait.Milliseconds()
+	stats.WaitMax = max(stats.WaitMax, wait.Milliseconds())
+	mu.Unlock()
+
+	start := time.Now()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			mu.Lock()
+			defer mu.Unlock()
+
+			hold := time.Since(start)
+			if holdAvg == 0 {
+				holdAvg = hold
+			} else {
+				holdAvg = (holdAvg*4 + hold) / 5
+			}
+			free(req)
+			dispatch()
+		})
+	}
+}
+
+// * admit waiters in order, one blocked by its own limit does not block others
+func dispatch() {
+	kept := waiters[:0]
+	for _, w := range waiters {
+		if fits(w.req) {
+			take(w.req)
+			w.admitted = true
+			close(w.ready)
+			continue
+		}
+		kept = append(kept, w)
+	}
+	waiters = kept
+}
+
+func fits(req Request) bool {
+	if maxConcurrency > 0 && running[globalKey] >= maxConcurrency {
+		return false
+	}
+	if limit := languageLimit(req.Language); limit > 0 && running["lang:"+req.Language] >= limit {
+		return false
+	}
+	if req.FunctionLimit > 0 && running["fn:"+req.Function] >= req.FunctionLimit {
+		return false
+	}
+	return true
+}
+
+func take(req Request) {
+	running[globalKey]++
+	running["lang:"+req.Language]++
+	running["fn:"+req.Function]++
+}
+
+func free(req Request) {
+	for _, key := range []string{globalKey, "lang:" + req.Language, "fn:" + req.Function} {
+		running[key]--
+		if running[key] <= 0 {
+			delete(running, key)
+		}
+	}
+}
+
+func remove(w *waiter) {
+	for i, other := range waiters {
+		if other == w {
+			waiters = append(waiters[:i], waiters[i+1:]...)
+			return
+		}
+	}
+}
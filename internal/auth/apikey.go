@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pardnchiu/go-faas/internal/database"
+	"github.com/pardnchiu/go-faas/internal/utils"
+)
+
+const (
+	keyPrefix  = "faas_"
+	keyTimeout = 5 * time.Second
+)
+
+var errInvalidKey = errors.New("invalid api key")
+
+// * plain key shown once, only its hash is stored
+func NewKey() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", fmt.Errorf("failed to generate key: %w", err)
+	}
+	key := keyPrefix + hex.EncodeToString(b)
+	return key, HashKey(key), nil
+}
+
+func HashKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+func authenticateKey(c *gin.Context) (*Principal, error) {
+	key := c.GetHeader("X-API-Key")
+
+	// * bootstrap key from env, creates the first stored keys
+	if admin := utils.GetWithDefault("AUTH_ADMIN_KEY", ""); admin != "" &&
+		subtle.ConstantTimeCompare([]byte(key), []byte(admin)) == 1 {
+		return &Principal{Subject: "admin", Scopes: []string{ScopeAdmin}}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), keyTimeout)
+	defer cancel()
+
+	stored, err := database.DB.GetKeyByHash(ctx, HashKey(key))
+	if err != nil {
+		if !errors.Is(err, database.ErrKeyNotFound) {
+			slog.Error("failed to get api key",
+				slog.String("error", err.Error()),
+			)
+		}
+		return nil, errInvalidKey
+	}
+
+	p := &Principal{
+		Subject: "key:" + stored.ID,
+		Scopes:  stored.Scopes,
+	}
+	if stored.Prefix != "" {
+		p.Paths = []string{stored.Prefix}
+	}
+	return p, nil
+}
@@ -0,0 +1,175 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pardnchiu/go-faas/internal/utils"
+)
+
+const (
+	ScopeUpload = "upload"
+	ScopeRun    = "run"
+	ScopeRunNow = "run-now"
+	ScopeAdmin  = "admin"
+
+	principalKey = "auth_principal"
+)
+
+var (
+	Scopes = []string{ScopeUpload, ScopeRun, ScopeRunNow, ScopeAdmin}
+
+	errNoCredential = errors.New("missing credential")
+
+	modeOnce sync.Once
+	modes    map[string]bool
+)
+
+// * authenticated caller, nil Paths allows every function, empty Paths none
+type Principal struct {
+	Subject string
+	Scopes  []string
+	Paths   []string
+}
+
+// * AUTH_MODE is a comma list of apikey and jwt, empty or "none" keeps the api open
+func getModes() map[string]bool {
+	modeOnce.Do(func() {
+		modes = map[string]bool{}
+		for _, mode := range strings.Split(utils.GetWithDefault("AUTH_MODE", "none"), ",") {
+			if mode = strings.TrimSpace(mode); mode != "" && mode != "none" {
+				modes[mode] = true
+			}
+		}
+		if len(modes) == 0 {
+			slog.Warn("authentication disabled, set AUTH_MODE to protect the api")
+		}
+	})
+	return modes
+}
+
+// * parse AUTH_MODE and load jwt keys, fails on unreadable key files
+func Init() error {
+	if getModes()["jwt"] {
+		if err := loadJWTKeys(); err != nil {
+			return fmt.Errorf("failed to load jwt keys: %w", err)
+		}
+		slog.Info("jwt keys loaded", slog.Int("count", len(jwtKeys)))
+	}
+	return nil
+}
+
+// * caller needs any of the scopes, admin passes every check
+// * path of a catch-all route is checked here, other paths by handler
+func Require(scopes ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if len(getModes()) == 0 {
+			c.Next()
+			return
+		}
+
+		p, err := authenticate(c)
+		if err != nil {
+			c.String(http.StatusUnauthorized, "unauthorized: "+err.Error())
+			c.Abort()
+			return
+		}
+		c.Set(principalKey, p)
+
+		if !slices.ContainsFunc(scopes, p.HasScope) {
+			c.String(http.StatusForbidden, "forbidden: requires scope "+strings.Join(scopes, " or "))
+			c.Abort()
+			return
+		}
+		if path := c.Param("targetPath"); path != "" && !p.AllowPath(path) {
+			c.String(http.StatusForbidden, "forbidden: path not allowed")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// * header carrying caller credentials, kept from function code when auth is on
+func IsCredentialHeader(name string) bool {
+	if len(getModes()) == 0 {
+		return false
+	}
+	switch strings.ToLower(name) {
+	case "authorization", "x-api-key", "cookie":
+		return true
+	}
+	return false
+}
+
+func authenticate(c *gin.Context) (*Principal, error) {
+	if getModes()["apikey"] && c.GetHeader("X-API-Key") != "" {
+		return authenticateKey(c)
+	}
+	if token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok && getModes()["jwt"] {
+		return authenticateJWT(strings.TrimSpace(token))
+	}
+	return nil, errNoCredential
+}
+
+func (p *Principal) HasScope(scope string) bool {
+	return slices.Contains(p.Scopes, ScopeAdmin) || slices.Contains(p.Scopes, scope)
+}
+
+func (p *Principal) AllowPath(path string) bool {
+	if p.Paths == nil {
+		return true
+	}
+	path = strings.Trim(path, "/")
+	for _, prefix := range p.Paths {
+		// * whole segments only, prefix billing does not reach billing-admin
+		prefix = strings.Trim(prefix, "/")
+		if prefix == "" || path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// * verified caller recorded with uploads and runs, empty when auth disabled
+func Subject(c *gin.Context) string {
+	if p := GetPrincipal(c); p != nil {
+		return p.Subject
+	}
+	return ""
+}
+
+// * nil when auth disabled
+func GetPrincipal(c *gin.Context) *Principal {
+	v, ok := c.Get(principalKey)
+	if !ok {
+		return nil
+	}
+	return v.(*Principal)
+}
+
+// * true when auth disabled
+func HasScope(c *gin.Context, scope string) bool {
+	p := GetPrincipal(c)
+	return p == nil || p.HasScope(scope)
+}
+
+// * granted path prefixes, nil when every path is allowed
+func AllowedPaths(c *gin.Context) []string {
+	if p := GetPrincipal(c); p != nil {
+		return p.Paths
+	}
+	return nil
+}
+
+// * true when auth disabled
+func AllowPath(c *gin.Context, path string) bool {
+	p := GetPrincipal(c)
+	return p == nil || p.AllowPath(path)
+}
@@ -0,0 +1,373 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pardnchiu/go-faas/internal/utils"
+)
+
+// * clock skew allowed on exp and nbf
+const jwtLeeway = 60 * time.Second
+
+var (
+	errInvalidToken = errors.New("invalid token")
+	errExpiredToken = errors.New("token expired")
+
+	jwtKeys []jwtKey
+)
+
+// * empty kid matches any token, static pem keys have none
+type jwtKey struct {
+	kid string
+	key crypto.PublicKey
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type jwtClaims struct {
+	Subject   string   `json:"sub"`
+	Issuer    string   `json:"iss"`
+	Audience  audience `json:"aud"`
+	ExpiresAt float64  `json:"exp"`
+	NotBefore float64  `json:"nbf"`
+}
+
+// * aud is a single string or an array of strings
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = audience{s}
+		return nil
+	}
+	return json.Unmarshal(b, (*[]string)(a))
+}
+
+// * keys from AUTH_JWT_JWKS_FILE and AUTH_JWT_PUBLIC_KEYS, read once at startup
+func loadJWTKeys() error {
+	jwtKeys = nil
+
+	if path := utils.GetWithDefault("AUTH_JWT_JWKS_FILE", ""); path != "" {
+		keys, err := readJWKS(path)
+		if err != nil {
+			return err
+		}
+		jwtKeys = append(jwtKeys, keys...)
+	}
+
+	for _, path := range strings.Split(utils.GetWithDefault("AUTH_JWT_PUBLIC_KEYS", ""), ",") {
+		if path = strings.TrimSpace(path); path == "" {
+			continue
+		}
+		key, err := readPublicKey(path)
+		if err != nil {
+			return err
+		}
+		jwtKeys = append(jwtKeys, jwtKey{key: key})
+	}
+
+	if len(jwtKeys) == 0 {
+		return fmt.Errorf("jwt mode requires AUTH_JWT_JWKS_FILE or AUTH_JWT_PUBLIC_KEYS")
+	}
+	return nil
+}
+
+func readJWKS(path string) ([]jwtKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read jwks: %w", err)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(b, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse jwks: %w", err)
+	}
+
+	keys := make([]jwtKey, 0, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse jwk %q: %w", k.Kid, err)
+		}
+		keys = append(keys, jwtKey{kid: k.Kid, key: key})
+	}
+	return keys, nil
+}
+
+// * PKIX public key or certificate in PEM
+func readPublicKey(path string) (crypto.PublicKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read public key: %w", err)
+	}
+
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("failed to parse public key %s: no PEM block", path)
+	}
+	if block.Type == "CERTIFICATE" {
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate %s: %w", path, err)
+		}
+		return cert.PublicKey, nil
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key %s: %w", path, err)
+	}
+	return key, nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, fmt.Errorf("invalid key parameter")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// * scope claim maps to operations, paths claim to function prefixes
+func authenticateJWT(token string) (*Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errInvalidToken
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, errInvalidToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errInvalidToken
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	if !slices.ContainsFunc(jwtKeys, func(k jwtKey) bool {
+		return (k.kid == "" || header.Kid == "" || k.kid == header.Kid) &&
+			verifySignature(header.Alg, k.key, signed, sig)
+	}) {
+		return nil, errInvalidToken
+	}
+
+	var claims jwtClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, errInvalidToken
+	}
+	if err := claims.validate(time.Now()); err != nil {
+		return nil, err
+	}
+
+	var raw map[string]json.RawMessage
+	if err := decodeSegment(parts[1], &raw); err != nil {
+		return nil, errInvalidToken
+	}
+	scopes, err := claimList(raw, utils.GetWithDefault("AUTH_JWT_SCOPE_CLAIM", "scope"))
+	if err != nil {
+		return nil, errInvalidToken
+	}
+	pathsClaim := utils.GetWithDefault("AUTH_JWT_PATHS_CLAIM", "faas_paths")
+	paths, err := claimList(raw, pathsClaim)
+	if err != nil {
+		return nil, errInvalidToken
+	}
+	// * missing or empty claim reaches no function, unless every path is opted in
+	if len(paths) == 0 {
+		paths = []string{}
+		if _, ok := raw[pathsClaim]; !ok && utils.GetWithDefault("AUTH_JWT_ALLOW_ALL_PATHS", "false") == "true" {
+			paths = nil
+		}
+	}
+
+	return &Principal{
+		Subject: "jwt:" + claims.Subject,
+		Scopes:  scopes,
+		Paths:   paths,
+	}, nil
+}
+
+func (c jwtClaims) validate(now time.Time) error {
+	if c.Subject == "" {
+		return fmt.Errorf("%w: missing sub", errInvalidToken)
+	}
+	// * tokens without exp never expire, not accepted
+	if c.ExpiresAt == 0 {
+		return fmt.Errorf("%w: missing exp", errInvalidToken)
+	}
+	if now.Add(-jwtLeeway).Unix() >= int64(c.ExpiresAt) {
+		return errExpiredToken
+	}
+	if c.NotBefore != 0 && now.Add(jwtLeeway).Unix() < int64(c.NotBefore) {
+		return fmt.Errorf("%w: not valid yet", errInvalidToken)
+	}
+	if issuer := utils.GetWithDefault("AUTH_JWT_ISSUER", ""); issuer != "" && c.Issuer != issuer {
+		return fmt.Errorf("%w: issuer mismatch", errInvalidToken)
+	}
+	if aud := utils.GetWithDefault("AUTH_JWT_AUDIENCE", ""); aud != "" && !slices.Contains(c.Audience, aud) {
+		return fmt.Errorf("%w: audience mismatch", errInvalidToken)
+	}
+	return nil
+}
+
+// * alg picks the hash, key type must match, none and HMAC are rejected
+func verifySignature(alg string, key crypto.PublicKey, signed, sig []byte) bool {
+	if alg == "EdDSA" {
+		pub, ok := key.(ed25519.PublicKey)
+		return ok && ed25519.Verify(pub, signed, sig)
+	}
+
+	var hash crypto.Hash
+	switch alg[min(len(alg), 2):] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	}
+	if hash == 0 {
+		return false
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch alg[:2] {
+	case "RS":
+		pub, ok := key.(*rsa.PublicKey)
+		return ok && rsa.VerifyPKCS1v15(pub, hash, digest, sig) == nil
+	case "PS":
+		pub, ok := key.(*rsa.PublicKey)
+		return ok && rsa.VerifyPSS(pub, hash, digest, sig, nil) == nil
+	case "ES":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return false
+		}
+		// * curve is fixed by alg, ES256 only on P-256
+		bits := pub.Curve.Params().BitSize
+		if bits != map[crypto.Hash]int{crypto.SHA256: 256, crypto.SHA384: 384, crypto.SHA512: 521}[hash] {
+			return false
+		}
+		// * r || s, each padded to curve size
+		size := (bits + 7) / 8
+		if len(sig) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		return ecdsa.Verify(pub, digest, r, s)
+	}
+	return false
+}
+
+func decodeSegment(seg string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// * space separated string or array of strings, missing claim is empty
+func claimList(raw map[string]json.RawMessage, name string) ([]string, error) {
+	b, ok := raw[name]
+	if !ok {
+		return nil, nil
+	}
+	return stringList(b)
+}
+
+func stringList(b []byte) ([]string, error) {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		return strings.Fields(s), nil
+	}
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
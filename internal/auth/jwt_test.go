@@ -0,0 +1,365 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+type testKeys struct {
+	rsa   *rsa.PrivateKey
+	p256  *ecdsa.PrivateKey
+	p384  *ecdsa.PrivateKey
+	ed    ed25519.PrivateKey
+	edPub ed25519.PublicKey
+}
+
+func newTestKeys(t *testing.T) testKeys {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPub, ed, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testKeys{rsa: rsaKey, p256: p256, p384: p384, ed: ed, edPub: edPub}
+}
+
+func segment(t *testing.T, v any) string {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// * sign header.claims with key, header alg picks the scheme unless signAs is set
+func signToken(t *testing.T, header, claims map[string]any, key any, signAs ...string) string {
+	t.Helper()
+	signed := segment(t, header) + "." + segment(t, claims)
+	alg, _ := header["alg"].(string)
+	if len(signAs) > 0 {
+		alg = signAs[0]
+	}
+
+	var sig []byte
+	var err error
+	switch k := key.(type) {
+	case nil:
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(k, []byte(signed))
+	case *rsa.PrivateKey:
+		digest := sha256.Sum256([]byte(signed))
+		if alg == "PS256" {
+			sig, err = rsa.SignPSS(rand.Reader, k, crypto.SHA256, digest[:], nil)
+		} else {
+			sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		}
+	case *ecdsa.PrivateKey:
+		h := crypto.SHA256
+		if alg == "ES384" {
+			h = crypto.SHA384
+		}
+		d := h.New()
+		d.Write([]byte(signed))
+		r, s, signErr := ecdsa.Sign(rand.Reader, k, d.Sum(nil))
+		if signErr != nil {
+			t.Fatal(signErr)
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		sig = make([]byte, 2*size)
+		r.FillBytes(sig[:size])
+		s.FillBytes(sig[size:])
+	case derSigner:
+		digest := sha256.Sum256([]byte(signed))
+		sig, err = ecdsa.SignASN1(rand.Reader, k.key, digest[:])
+	default:
+		t.Fatalf("unknown key %T", key)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// * ecdsa signature in ASN.1 DER instead of r || s
+type derSigner struct {
+	key *ecdsa.PrivateKey
+}
+
+func TestAuthenticateJWTSignature(t *testing.T) {
+	keys := newTestKeys(t)
+	rsaDER, err := x509.MarshalPKIXPublicKey(&keys.rsa.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwtKeys = []jwtKey{
+		{kid: "rsa", key: &keys.rsa.PublicKey},
+		{kid: "p256", key: &keys.p256.PublicKey},
+		{kid: "p384", key: &keys.p384.PublicKey},
+		{kid: "ed", key: keys.edPub},
+	}
+	t.Cleanup(func() { jwtKeys = nil })
+
+	claims := map[string]any{
+		"sub":        "svc",
+		"exp":        time.Now().Add(time.Hour).Unix(),
+		"faas_paths": "billing/",
+	}
+
+	tests := []struct {
+		name   string
+		header map[string]any
+		key    any
+		signAs string
+		ok     bool
+	}{
+		{"RS256", map[string]any{"alg": "RS256", "kid": "rsa"}, keys.rsa, "", true},
+		{"PS256", map[string]any{"alg": "PS256", "kid": "rsa"}, keys.rsa, "", true},
+		{"ES256", map[string]any{"alg": "ES256", "kid": "p256"}, keys.p256, "", true},
+		{"ES384", map[string]any{"alg": "ES384", "kid": "p384"}, keys.p384, "", true},
+		{"EdDSA", map[string]any{"alg": "EdDSA", "kid": "ed"}, keys.ed, "", true},
+		{"no kid tries every key", map[string]any{"alg": "ES256"}, keys.p256, "", true},
+		{"kid of other key", map[string]any{"alg": "RS256", "kid": "p256"}, keys.rsa, "", false},
+		{"unknown kid", map[string]any{"alg": "RS256", "kid": "gone"}, keys.rsa, "", false},
+		{"RS alg on ec key", map[string]any{"alg": "RS256", "kid": "p256"}, keys.p256, "", false},
+		{"ES alg on rsa key", map[string]any{"alg": "ES256", "kid": "rsa"}, keys.rsa, "", false},
+		{"PS alg on pkcs1 signature", map[string]any{"alg": "PS256", "kid": "rsa"}, keys.rsa, "RS256", false},
+		{"ES256 on P-384 key", map[string]any{"alg": "ES256", "kid": "p384"}, keys.p384, "", false},
+		{"EdDSA alg on rsa key", map[string]any{"alg": "EdDSA", "kid": "rsa"}, keys.rsa, "", false},
+		{"none", map[string]any{"alg": "none"}, nil, "", false},
+		{"empty alg", map[string]any{"alg": ""}, nil, "", false},
+		{"HS256 with public key as secret", map[string]any{"alg": "HS256", "kid": "rsa"}, rsaDER, "", false},
+		{"ES256 DER signature", map[string]any{"alg": "ES256", "kid": "p256"}, derSigner{keys.p256}, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signAs := tt.signAs
+			if signAs == "" {
+				signAs, _ = tt.header["alg"].(string)
+			}
+			p, err := authenticateJWT(signToken(t, tt.header, claims, tt.key, signAs))
+			if tt.ok {
+				if err != nil {
+					t.Fatalf("err = %v, want nil", err)
+				}
+				if p.Subject != "jwt:svc" {
+					t.Fatalf("subject = %q", p.Subject)
+				}
+				return
+			}
+			if !errors.Is(err, errInvalidToken) {
+				t.Fatalf("err = %v, want errInvalidToken", err)
+			}
+		})
+	}
+}
+
+func TestAuthenticateJWTMalformed(t *testing.T) {
+	keys := newTestKeys(t)
+	jwtKeys = []jwtKey{{key: keys.edPub}}
+	t.Cleanup(func() { jwtKeys = nil })
+
+	valid := signToken(t, map[string]any{"alg": "EdDSA"}, map[string]any{
+		"sub": "svc",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}, keys.ed)
+
+	for _, token := range []string{
+		"",
+		"a.b",
+		"a.b.c.d",
+		"!.!.!",
+		valid + "x",
+		valid[:len(valid)-4],
+	} {
+		if _, err := authenticateJWT(token); !errors.Is(err, errInvalidToken) {
+			t.Fatalf("%q: err = %v, want errInvalidToken", token, err)
+		}
+	}
+}
+
+func TestJWTClaimsValidate(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	exp := float64(now.Add(time.Hour).Unix())
+
+	tests := []struct {
+		name     string
+		claims   jwtClaims
+		audience string
+		issuer   string
+		err      error
+	}{
+		{"valid", jwtClaims{Subject: "s", ExpiresAt: exp}, "", "", nil},
+		{"missing sub", jwtClaims{ExpiresAt: exp}, "", "", errInvalidToken},
+		{"missing exp", jwtClaims{Subject: "s"}, "", "", errInvalidToken},
+		{"expired within leeway", jwtClaims{Subject: "s", ExpiresAt: float64(now.Add(-30 * time.Second).Unix())}, "", "", nil},
+		{"expired beyond leeway", jwtClaims{Subject: "s", ExpiresAt: float64(now.Add(-90 * time.Second).Unix())}, "", "", errExpiredToken},
+		{"expired at leeway", jwtClaims{Subject: "s", ExpiresAt: float64(now.Add(-jwtLeeway).Unix())}, "", "", errExpiredToken},
+		{"nbf within leeway", jwtClaims{Subject: "s", ExpiresAt: exp, NotBefore: float64(now.Add(30 * time.Second).Unix())}, "", "", nil},
+		{"nbf beyond leeway", jwtClaims{Subject: "s", ExpiresAt: exp, NotBefore: float64(now.Add(90 * time.Second).Unix())}, "", "", errInvalidToken},
+		{"issuer match", jwtClaims{Subject: "s", ExpiresAt: exp, Issuer: "idp"}, "", "idp", nil},
+		{"issuer mismatch", jwtClaims{Subject: "s", ExpiresAt: exp, Issuer: "other"}, "", "idp", errInvalidToken},
+		{"issuer missing", jwtClaims{Subject: "s", ExpiresAt: exp}, "", "idp", errInvalidToken},
+		{"audience match", jwtClaims{Subject: "s", ExpiresAt: exp, Audience: audience{"faas"}}, "faas", "", nil},
+		{"audience in list", jwtClaims{Subject: "s", ExpiresAt: exp, Audience: audience{"other", "faas"}}, "faas", "", nil},
+		{"audience mismatch", jwtClaims{Subject: "s", ExpiresAt: exp, Audience: audience{"other"}}, "faas", "", errInvalidToken},
+		{"audience missing", jwtClaims{Subject: "s", ExpiresAt: exp}, "faas", "", errInvalidToken},
+		{"audience not configured", jwtClaims{Subject: "s", ExpiresAt: exp, Audience: audience{"other"}}, "", "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("AUTH_JWT_AUDIENCE", tt.audience)
+			t.Setenv("AUTH_JWT_ISSUER", tt.issuer)
+			err := tt.claims.validate(now)
+			if tt.err == nil && err != nil {
+				t.Fatalf("err = %v, want nil", err)
+			}
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestAudienceUnmarshal(t *testing.T) {
+	tests := []struct {
+		json string
+		want audience
+		ok   bool
+	}{
+		{`"faas"`, audience{"faas"}, true},
+		{`["faas","other"]`, audience{"faas", "other"}, true},
+		{`[]`, audience{}, true},
+		{`1`, nil, false},
+		{`[1]`, nil, false},
+	}
+
+	for _, tt := range tests {
+		var got audience
+		err := json.Unmarshal([]byte(tt.json), &got)
+		if (err == nil) != tt.ok {
+			t.Fatalf("%s: err = %v", tt.json, err)
+		}
+		if tt.ok && !slices.Equal(got, tt.want) {
+			t.Fatalf("%s: got %v, want %v", tt.json, got, tt.want)
+		}
+	}
+}
+
+func TestAuthenticateJWTClaims(t *testing.T) {
+	keys := newTestKeys(t)
+	jwtKeys = []jwtKey{{key: keys.edPub}}
+	t.Cleanup(func() { jwtKeys = nil })
+
+	exp := time.Now().Add(time.Hour).Unix()
+	tests := []struct {
+		name     string
+		claims   map[string]any
+		allowAll string
+		scopes   []string
+		allow    []string
+		deny     []string
+	}{
+		{
+			name:   "string claims",
+			claims: map[string]any{"sub": "s", "exp": exp, "scope": "run upload", "faas_paths": "billing/ shared"},
+			scopes: []string{"run", "upload"},
+			allow:  []string{"billing/invoice", "shared"},
+			deny:   []string{"billing-admin/x", "other"},
+		},
+		{
+			name:   "array claims",
+			claims: map[string]any{"sub": "s", "exp": exp, "scope": []string{"run"}, "faas_paths": []string{"billing"}},
+			scopes: []string{"run"},
+			allow:  []string{"billing", "billing/x"},
+			deny:   []string{"other"},
+		},
+		{
+			name:   "missing paths denies",
+			claims: map[string]any{"sub": "s", "exp": exp, "scope": "run"},
+			scopes: []string{"run"},
+			deny:   []string{"billing", "other"},
+		},
+		{
+			name:     "missing paths with opt in",
+			claims:   map[string]any{"sub": "s", "exp": exp},
+			allowAll: "true",
+			allow:    []string{"billing", "other/x"},
+		},
+		{
+			name:     "empty paths denies even with opt in",
+			claims:   map[string]any{"sub": "s", "exp": exp, "faas_paths": []string{}},
+			allowAll: "true",
+			deny:     []string{"billing"},
+		},
+		{
+			name:   "null paths denies",
+			claims: map[string]any{"sub": "s", "exp": exp, "faas_paths": nil},
+			deny:   []string{"billing"},
+		},
+		{
+			name:   "root grant",
+			claims: map[string]any{"sub": "s", "exp": exp, "faas_paths": []string{"/"}},
+			allow:  []string{"billing", "other/x"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("AUTH_JWT_ALLOW_ALL_PATHS", tt.allowAll)
+			p, err := authenticateJWT(signToken(t, map[string]any{"alg": "EdDSA"}, tt.claims, keys.ed))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(p.Scopes, tt.scopes) {
+				t.Fatalf("scopes = %v, want %v", p.Scopes, tt.scopes)
+			}
+			for _, path := range tt.allow {
+				if !p.AllowPath(path) {
+					t.Fatalf("path %q denied, paths %v", path, p.Paths)
+				}
+			}
+			for _, path := range tt.deny {
+				if p.AllowPath(path) {
+					t.Fatalf("path %q allowed, paths %v", path, p.Paths)
+				}
+			}
+		})
+	}
+
+	// * malformed claim is not read as missing
+	token := signToken(t, map[string]any{"alg": "EdDSA"}, map[string]any{"sub": "s", "exp": exp, "faas_paths": 1}, keys.ed)
+	if _, err := authenticateJWT(token); !errors.Is(err, errInvalidToken) {
+		t.Fatalf("err = %v, want errInvalidToken", err)
+	}
+}
@@ -0,0 +1,75 @@
+package database
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+)
+
+// * Weight percent of calls run Canary, the rest run Version
+type Alias struct {
+	Name    string `json:"name"`
+	Version int64  `json:"version"`
+	Canary  int64  `json:"canary,omitempty"`
+	Weight  int    `json:"weight,omitempty"`
+}
+
+func (a Alias) Validate() error {
+	if a.Name == "" || strings.ContainsAny(a.Name, ",/: ") {
+		return fmt.Errorf("invalid alias name")
+	}
+	if a.Version <= 0 {
+		return fmt.Errorf("version is required")
+	}
+	if a.Canary == 0 && a.Weight != 0 {
+		return fmt.Errorf("weight requires canary version")
+	}
+	if a.Canary != 0 && (a.Weight <= 0 || a.Weight >= 100) {
+		return fmt.Errorf("weight must be between 1 and 99")
+	}
+	return nil
+}
+
+// * pick version for one call by weight
+func (a Alias) Resolve() int64 {
+	if a.Canary != 0 && rand.IntN(100) < a.Weight {
+		return a.Canary
+	}
+	return a.Version
+}
+
+func (a Alias) pinned() []int64 {
+	if a.Canary != 0 {
+		return []int64{a.Version, a.Canary}
+	}
+	return []int64{a.Version}
+}
+
+// * stored as "version,canary,weight" in meta field alias:<name>
+func (a Alias) encode() string {
+	return fmt.Sprintf("%d,%d,%d", a.Version, a.Canary, a.Weight)
+}
+
+func decodeAlias(name, value string) (Alias, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) != 3 {
+		return Alias{}, fmt.Errorf("invalid alias: %s", name)
+	}
+
+	var nums [3]int64
+	for i, p := range parts {
+		n, err := strconv.ParseInt(p, 10, 64)
+		if err != nil {
+			return Alias{}, fmt.Errorf("invalid alias: %s", name)
+		}
+		nums[i] = n
+	}
+
+	return Alias{
+		Name:    name,
+		Version: nums[0],
+		Canary:  nums[1],
+		Weight:  int(nums[2]),
+	}, nil
+}
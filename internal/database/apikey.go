@@ -0,0 +1,27 @@
+package database
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	ErrKeyNotFound = errors.New("api key not found")
+)
+
+type KeyStore interface {
+	SaveKey(ctx context.Context, key APIKey) error
+	// * lookup by sha-256 of the secret, plain key is never stored
+	GetKeyByHash(ctx context.Context, hash string) (*APIKey, error)
+	ListKeys(ctx context.Context) ([]APIKey, error)
+	DeleteKey(ctx context.Context, id string) error
+}
+
+type APIKey struct {
+	ID        string   `json:"id"`
+	Name      string   `json:"name,omitempty"`
+	Hash      string   `json:"hash,omitempty"`
+	Scopes    []string `json:"scopes"`
+	Prefix    string   `json:"prefix,omitempty"`
+	CreatedAt int64    `json:"created_at"`
+}
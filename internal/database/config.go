@@ -0,0 +1,114 @@
+package database
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/pardnchiu/go-faas/internal/utils"
+)
+
+var (
+	envKeyRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	// * set by sandbox, can not be overwritten by function
+	reservedEnv = map[string]bool{
+		"HOME":            true,
+		"PATH":            true,
+		"TMPDIR":          true,
+		"LANG":            true,
+		"NODE_PATH":       true,
+		"LD_PRELOAD":      true,
+		"LD_LIBRARY_PATH": true,
+		// * used by systemd-run on the host side
+		"XDG_RUNTIME_DIR":          true,
+		"DBUS_SESSION_BUS_ADDRESS": true,
+	}
+)
+
+// * resource limits of one invocation, zero value uses server defaults
+type Limits struct {
+	Memory string  `json:"memory,omitempty"`
+	CPU    float64 `json:"cpu,omitempty"`
+	Tasks  int     `json:"tasks,omitempty"`
+	Swap   string  `json:"swap,omitempty"`
+}
+
+// * zero value fields fall back to server defaults
+type Config struct {
+	Timeout int `json:"timeout,omitempty"`
+	Limits
+	Concurrency  int               `json:"concurrency,omitempty"`
+	Env          map[string]string `json:"env,omitempty"`
+	Description  string            `json:"description,omitempty"`
+	FailOnStderr bool              `json:"fail_on_stderr,omitempty"`
+}
+
+func (l Limits) Validate() error {
+	if l.CPU < 0 {
+		return fmt.Errorf("cpu must be positive")
+	}
+	if l.Tasks < 0 {
+		return fmt.Errorf("tasks must be positive")
+	}
+	if l.Memory != "" {
+		size, err := utils.ParseSize(l.Memory)
+		if err != nil {
+			return fmt.Errorf("memory: %w", err)
+		}
+		if size == 0 {
+			return fmt.Errorf("memory must be positive")
+		}
+	}
+	if l.Swap != "" {
+		if _, err := utils.ParseSize(l.Swap); err != nil {
+			return fmt.Errorf("swap: %w", err)
+		}
+	}
+	return nil
+}
+
+// * non-zero fields of other take precedence
+func (l Limits) Merge(other Limits) Limits {
+	if other.Memory != "" {
+		l.Memory = other.Memory
+	}
+	if other.CPU > 0 {
+		l.CPU = other.CPU
+	}
+	if other.Tasks > 0 {
+		l.Tasks = other.Tasks
+	}
+	if other.Swap != "" {
+		l.Swap = other.Swap
+	}
+	return l
+}
+
+func (c Config) Validate() error {
+	if c.Timeout < 0 {
+		return fmt.Errorf("timeout must be positive")
+	}
+	if err := c.Limits.Validate(); err != nil {
+		return err
+	}
+	if c.Concurrency < 0 {
+		return fmt.Errorf("concurrency must be positive")
+	}
+	for key := range c.Env {
+		if !envKeyRegex.MatchString(key) {
+			return fmt.Errorf("invalid env name: %s", key)
+		}
+		if reservedEnv[key] {
+			return fmt.Errorf("reserved env name: %s", key)
+		}
+	}
+	return nil
+}
+
+func (c Config) isZero() bool {
+	return c.Timeout == 0 &&
+		c.Limits == Limits{} &&
+		c.Concurrency == 0 &&
+		len(c.Env) == 0 &&
+		c.Description == "" &&
+		!c.FailOnStderr
+}
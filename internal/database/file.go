@@ -0,0 +1,823 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// * on-disk layout mirrors the redis keys:
+// * <root>/<hash>/meta.json     => meta:<hash>
+// * <root>/<hash>/code/<ver>    => meta:<hash>:code field <ver>
+// * entries of <root>/<hash>/code => meta:<hash>:version
+// * <root>/<hash>/config/<ver>  => meta:<hash>:config field <ver>
+// * <root>/<hash>/seq          => meta:<hash>:seq
+// * <root>/index.json          => index:path
+// * <root>/schedules.json      => schedules with last run
+type FileStore struct {
+	root string
+	mu   sync.RWMutex
+	// * file store serves single instance, claims kept in memory
+	claims map[string]int64
+}
+
+type fileMeta struct {
+	Path     string           `json:"path"`
+	Language string           `json:"language"`
+	Latest   int64            `json:"latest"`
+	Aliases  map[string]Alias `json:"aliases,omitempty"`
+}
+
+func NewFileStore(root string) (*FileStore, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create store folder: %w", err)
+	}
+	return &FileStore{
+		root:   root,
+		claims: map[string]int64{},
+	}, nil
+}
+
+func (db *FileStore) Close() error {
+	return nil
+}
+
+func (db *FileStore) Add(ctx context.Context, script Script) (int64, error) {
+	timestamp, err := db.add(script)
+	if err != nil {
+		return 0, err
+	}
+
+	prune(ctx, db, script.Path)
+
+	return timestamp, nil
+}
+
+func (db *FileStore) add(script Script) (int64, error) {
+	hashStr := hashPath(script.Path)
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	codeFolder := filepath.Join(db.root, hashStr, "code")
+	if err := os.MkdirAll(codeFolder, 0755); err != nil {
+		return 0, fmt.Errorf("failed to create code folder: %w", err)
+	}
+
+	version, err := db.nextVersion(hashStr)
+	if err != nil {
+		return 0, fmt.Errorf("failed to allocate version: %w", err)
+	}
+
+	if !script.Config.isZero() {
+		b, err := json.Marshal(script.Config)
+		if err != nil {
+			return 0, fmt.Errorf("failed to marshal config: %w", err)
+		}
+		if err := os.MkdirAll(filepath.Join(db.root, hashStr, "config"), 0755); err != nil {
+			return 0, fmt.Errorf("failed to create config folder: %w", err)
+		}
+		if err := writeFileAtomic(db.configPath(hashStr, version), b); err != nil {
+			return 0, fmt.Errorf("failed to save config: %w", err)
+		}
+	}
+
+	if script.Author != "" {
+		if err := os.MkdirAll(filepath.Join(db.root, hashStr, "author"), 0755); err != nil {
+			return 0, fmt.Errorf("failed to create author folder: %w", err)
+		}
+		if err := writeFileAtomic(db.authorPath(hashStr, version), []byte(script.Author)); err != nil {
+			return 0, fmt.Errorf("failed to save author: %w", err)
+		}
+	}
+
+	// * write code before meta, latest never points at missing code
+	codePath := db.codePath(hashStr, version)
+	if err := writeFileAtomic(codePath, []byte(script.Code)); err != nil {
+		return 0, fmt.Errorf("failed to save code: %w", err)
+	}
+
+	// * keep aliases of previous meta
+	meta, err := db.readMeta(hashStr)
+	if err != nil {
+		meta = &fileMeta{}
+	}
+	meta.Path = script.Path
+	meta.Language = script.Language
+	meta.Latest = version
+	if err := db.writeMeta(hashStr, *meta); err != nil {
+		return 0, fmt.Errorf("failed to update meta: %w", err)
+	}
+
+	if err := db.addIndex(script.Path); err != nil {
+		return 0, fmt.Errorf("failed to update index: %w", err)
+	}
+
+	return version, nil
+}
+
+// * caller must hold write lock
+func (db *FileStore) nextVersion(hashStr string) (int64, error) {
+	seqPath := filepath.Join(db.root, hashStr, "seq")
+
+	var seq int64
+	b, err := os.ReadFile(seqPath)
+	switch {
+	case err == nil:
+		seq, err = strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid seq: %w", err)
+		}
+	case errors.Is(err, os.ErrNotExist):
+		// * seq starts from the highest stored version, same as redis store
+		entries, err := os.ReadDir(filepath.Join(db.root, hashStr, "code"))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return 0, err
+		}
+		for _, entry := range entries {
+			if v, err := strconv.ParseInt(entry.Name(), 10, 64); err == nil {
+				seq = max(seq, v)
+			}
+		}
+	default:
+		return 0, err
+	}
+
+	seq++
+	if err := writeFileAtomic(seqPath, []byte(strconv.FormatInt(seq, 10))); err != nil {
+		return 0, err
+	}
+	return seq, nil
+}
+
+func (db *FileStore) Get(ctx context.Context, path string, version int64) (*Script, error) {
+	hashStr := hashPath(path)
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	// * get meta
+	meta, err := db.readMeta(hashStr)
+	if err != nil {
+		return nil, err
+	}
+
+	if version == 0 {
+		version = meta.Latest
+	}
+	return db.getCode(hashStr, meta, version)
+}
+
+func (db *FileStore) GetAlias(ctx context.Context, path, name string) (*Script, error) {
+	hashStr := hashPath(path)
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	meta, err := db.readMeta(hashStr)
+	if err != nil {
+		return nil, err
+	}
+
+	alias, ok := meta.Aliases[name]
+	if !ok {
+		return nil, ErrAliasNotFound
+	}
+	return db.getCode(hashStr, meta, alias.Resolve())
+}
+
+func (db *FileStore) getCode(hashStr string, meta *fileMeta, version int64) (*Script, error) {
+	if meta.Language == "" {
+		return nil, fmt.Errorf("language not found in meta")
+	}
+
+	code, err := os.ReadFile(db.codePath(hashStr, version))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrVersionNotFound
+		}
+		return nil, fmt.Errorf("failed to get script: %w", err)
+	}
+
+	var config Config
+	b, err := os.ReadFile(db.configPath(hashStr, version))
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(b, &config); err != nil {
+			return nil, fmt.Errorf("failed to parse config: %w", err)
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return nil, fmt.Errorf("failed to get config: %w", err)
+	}
+
+	return &Script{
+		Path:      meta.Path,
+		Code:      string(code),
+		Language:  meta.Language,
+		Timestamp: version,
+		Config:    config,
+	}, nil
+}
+
+func (db *FileStore) codePath(hashStr string, version int64) string {
+	return filepath.Join(db.root, hashStr, "code", strconv.FormatInt(version, 10))
+}
+
+func (db *FileStore) configPath(hashStr string, version int64) string {
+	return filepath.Join(db.root, hashStr, "config", strconv.FormatInt(version, 10))
+}
+
+func (db *FileStore) authorPath(hashStr string, version int64) string {
+	return filepath.Join(db.root, hashStr, "author", strconv.FormatInt(version, 10))
+}
+
+func (db *FileStore) List(ctx context.Context, opt ListOption) ([]Function, string, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	paths, err := db.readIndex()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list index: %w", err)
+	}
+
+	start := sort.SearchStrings(paths, opt.Prefix)
+	if opt.Cursor != "" && opt.Cursor >= opt.Prefix {
+		start = sort.Search(len(paths), func(i int) bool {
+			return paths[i] > opt.Cursor
+		})
+	}
+
+	list := []Function{}
+	for _, path := range paths[start:] {
+		if !strings.HasPrefix(path, opt.Prefix) {
+			break
+		}
+		if opt.Allow != nil && !opt.Allow(path) {
+			continue
+		}
+
+		hashStr := hashPath(path)
+		meta, err := db.readMeta(hashStr)
+		// * index entry without meta, skip
+		if err != nil {
+			continue
+		}
+		if opt.Language != "" && meta.Language != opt.Language {
+			continue
+		}
+
+		list = append(list, Function{
+			Path:     meta.Path,
+			Language: meta.Language,
+			Latest:   meta.Latest,
+			Versions: db.countVersions(hashStr),
+		})
+		if len(list) == opt.Limit {
+			return list, path, nil
+		}
+	}
+
+	return list, "", nil
+}
+
+func (db *FileStore) Versions(ctx context.Context, path string) (*Function, []Version, error) {
+	hashStr := hashPath(path)
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	meta, err := db.readMeta(hashStr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	entries, err := os.ReadDir(filepath.Join(db.root, hashStr, "code"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get versions: %w", err)
+	}
+
+	list := make([]Version, 0, len(entries))
+	for _, entry := range entries {
+		v, err := strconv.ParseInt(entry.Name(), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		author, _ := os.ReadFile(db.authorPath(hashStr, v))
+		list = append(list, Version{
+			Version:   v,
+			Size:      info.Size(),
+			CreatedAt: info.ModTime().Unix(),
+			Author:    string(author),
+		})
+	}
+	// * newest first
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Version > list[j].Version
+	})
+
+	return &Function{
+		Path:     meta.Path,
+		Language: meta.Language,
+		Latest:   meta.Latest,
+		Versions: int64(len(list)),
+	}, list, nil
+}
+
+func (db *FileStore) Delete(ctx context.Context, path string) error {
+	hashStr := hashPath(path)
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, err := db.readMeta(hashStr); err != nil {
+		return err
+	}
+
+	// * seq is kept, version ids are never reused when path is uploaded again
+	for _, folder := range []string{"code", "config", "author"} {
+		if err := os.RemoveAll(filepath.Join(db.root, hashStr, folder)); err != nil {
+			return fmt.Errorf("failed to delete script: %w", err)
+		}
+	}
+	if err := os.Remove(filepath.Join(db.root, hashStr, "meta.json")); err != nil {
+		return fmt.Errorf("failed to delete script: %w", err)
+	}
+
+	if err := db.removeIndex(path); err != nil {
+		return fmt.Errorf("failed to update index: %w", err)
+	}
+	return nil
+}
+
+func (db *FileStore) DeleteVersion(ctx context.Context, path string, version int64) error {
+	hashStr := hashPath(path)
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	meta, err := db.readMeta(hashStr)
+	if err != nil {
+		return err
+	}
+	if meta.Latest == version {
+		return ErrVersionInUse
+	}
+	for _, alias := range meta.Aliases {
+		if alias.Version == version || alias.Canary == version {
+			return ErrVersionAliased
+		}
+	}
+
+	if err := os.Remove(db.codePath(hashStr, version)); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return ErrVersionNotFound
+		}
+		return fmt.Errorf("failed to delete version: %w", err)
+	}
+	if err := os.Remove(db.configPath(hashStr, version)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete config: %w", err)
+	}
+	if err := os.Remove(db.authorPath(hashStr, version)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete author: %w", err)
+	}
+	return nil
+}
+
+func (db *FileStore) SetLatest(ctx context.Context, path string, version int64) error {
+	hashStr := hashPath(path)
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	meta, err := db.readMeta(hashStr)
+	if err != nil {
+		return err
+	}
+
+	if err := db.checkVersion(hashStr, version); err != nil {
+		return err
+	}
+
+	meta.Latest = version
+	if err := db.writeMeta(hashStr, *meta); err != nil {
+		return fmt.Errorf("failed to set latest: %w", err)
+	}
+	return nil
+}
+
+func (db *FileStore) Aliases(ctx context.Context, path string) ([]Alias, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	meta, err := db.readMeta(hashPath(path))
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]Alias, 0, len(meta.Aliases))
+	for _, alias := range meta.Aliases {
+		list = append(list, alias)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list, nil
+}
+
+func (db *FileStore) SetAlias(ctx context.Context, path string, alias Alias) error {
+	hashStr := hashPath(path)
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	meta, err := db.readMeta(hashStr)
+	if err != nil {
+		return err
+	}
+
+	for _, v := range alias.pinned() {
+		if err := db.checkVersion(hashStr, v); err != nil {
+			return err
+		}
+	}
+
+	if meta.Aliases == nil {
+		meta.Aliases = map[string]Alias{}
+	}
+	meta.Aliases[alias.Name] = alias
+	if err := db.writeMeta(hashStr, *meta); err != nil {
+		return fmt.Errorf("failed to set alias: %w", err)
+	}
+	return nil
+}
+
+func (db *FileStore) DeleteAlias(ctx context.Context, path, name string) error {
+	hashStr := hashPath(path)
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	meta, err := db.readMeta(hashStr)
+	if err != nil {
+		return err
+	}
+	if _, ok := meta.Aliases[name]; !ok {
+		return ErrAliasNotFound
+	}
+
+	delete(meta.Aliases, name)
+	if err := db.writeMeta(hashStr, *meta); err != nil {
+		return fmt.Errorf("failed to delete alias: %w", err)
+	}
+	return nil
+}
+
+func (db *FileStore) checkVersion(hashStr string, version int64) error {
+	if _, err := os.Stat(db.codePath(hashStr, version)); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return ErrVersionNotFound
+		}
+		return fmt.Errorf("failed to get version: %w", err)
+	}
+	return nil
+}
+
+// * skip temp files left by writeFileAtomic
+func (db *FileStore) countVersions(hashStr string) int64 {
+	entries, _ := os.ReadDir(filepath.Join(db.root, hashStr, "code"))
+
+	var count int64
+	for _, entry := range entries {
+		if _, err := strconv.ParseInt(entry.Name(), 10, 64); err == nil {
+			count++
+		}
+	}
+	return count
+}
+
+func (db *FileStore) readIndex() ([]string, error) {
+	b, err := os.ReadFile(filepath.Join(db.root, "index.json"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+
+	var paths []string
+	if err := json.Unmarshal(b, &paths); err != nil {
+		return nil, err
+	}
+	return paths, nil
+}
+
+func (db *FileStore) addIndex(path string) error {
+	paths, err := db.readIndex()
+	if err != nil {
+		return err
+	}
+
+	// * keep index sorted for prefix range lookup
+	i := sort.SearchStrings(paths, path)
+	if i < len(paths) && paths[i] == path {
+		return nil
+	}
+	paths = append(paths, "")
+	copy(paths[i+1:], paths[i:])
+	paths[i] = path
+
+	return db.writeIndex(paths)
+}
+
+func (db *FileStore) removeIndex(path string) error {
+	paths, err := db.readIndex()
+	if err != nil {
+		return err
+	}
+
+	i := sort.SearchStrings(paths, path)
+	if i >= len(paths) || paths[i] != path {
+		return nil
+	}
+
+	return db.writeIndex(append(paths[:i], paths[i+1:]...))
+}
+
+func (db *FileStore) writeIndex(paths []string) error {
+	b, err := json.Marshal(paths)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(db.root, "index.json"), b)
+}
+
+func (db *FileStore) readMeta(hashStr string) (*fileMeta, error) {
+	b, err := os.ReadFile(filepath.Join(db.root, hashStr, "meta.json"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrScriptNotFound
+		}
+		return nil, fmt.Errorf("failed to get meta: %w", err)
+	}
+
+	var meta fileMeta
+	if err := json.Unmarshal(b, &meta); err != nil {
+		return nil, fmt.Errorf("failed to parse meta: %w", err)
+	}
+	return &meta, nil
+}
+
+func (db *FileStore) writeMeta(hashStr string, meta fileMeta) error {
+	b, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(db.root, hashStr, "meta.json"), b)
+}
+
+// * write to temp file then rename, readers never see partial content
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+func (db *FileStore) SaveSchedule(ctx context.Context, schedule Schedule) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	schedules, err := db.readSchedules()
+	if err != nil {
+		return err
+	}
+
+	// * keep last run of existing schedule
+	if old, ok := schedules[schedule.ID]; ok {
+		schedule.LastRun = old.LastRun
+	} else {
+		schedule.LastRun = nil
+	}
+	schedules[schedule.ID] = schedule
+	return db.writeSchedules(schedules)
+}
+
+func (db *FileStore) GetSchedule(ctx context.Context, id string) (*Schedule, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	schedules, err := db.readSchedules()
+	if err != nil {
+		return nil, err
+	}
+	schedule, ok := schedules[id]
+	if !ok {
+		return nil, ErrScheduleNotFound
+	}
+	return &schedule, nil
+}
+
+func (db *FileStore) ListSchedules(ctx context.Context) ([]Schedule, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	schedules, err := db.readSchedules()
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]Schedule, 0, len(schedules))
+	for _, schedule := range schedules {
+		list = append(list, schedule)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+	return list, nil
+}
+
+func (db *FileStore) DeleteSchedule(ctx context.Context, id string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	schedules, err := db.readSchedules()
+	if err != nil {
+		return err
+	}
+	if _, ok := schedules[id]; !ok {
+		return ErrScheduleNotFound
+	}
+
+	delete(schedules, id)
+	delete(db.claims, id)
+	return db.writeSchedules(schedules)
+}
+
+func (db *FileStore) ClaimSchedule(ctx context.Context, id string, at int64) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.claims[id] >= at {
+		return false, nil
+	}
+	db.claims[id] = at
+	return true, nil
+}
+
+func (db *FileStore) SetScheduleRun(ctx context.Context, id string, run ScheduleRun) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	schedules, err := db.readSchedules()
+	if err != nil {
+		return err
+	}
+	schedule, ok := schedules[id]
+	if !ok {
+		return ErrScheduleNotFound
+	}
+
+	schedule.LastRun = &run
+	schedules[id] = schedule
+	return db.writeSchedules(schedules)
+}
+
+func (db *FileStore) readSchedules() (map[string]Schedule, error) {
+	b, err := os.ReadFile(filepath.Join(db.root, "schedules.json"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return map[string]Schedule{}, nil
+		}
+		return nil, fmt.Errorf("failed to get schedules: %w", err)
+	}
+
+	schedules := map[string]Schedule{}
+	if err := json.Unmarshal(b, &schedules); err != nil {
+		return nil, fmt.Errorf("failed to parse schedules: %w", err)
+	}
+	return schedules, nil
+}
+
+func (db *FileStore) writeSchedules(schedules map[string]Schedule) error {
+	b, err := json.Marshal(schedules)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(db.root, "schedules.json"), b); err != nil {
+		return fmt.Errorf("failed to save schedules: %w", err)
+	}
+	return nil
+}
+
+func (db *FileStore) SaveKey(ctx context.Context, key APIKey) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	keys, err := db.readKeys()
+	if err != nil {
+		return err
+	}
+	keys[key.ID] = key
+	return db.writeKeys(keys)
+}
+
+func (db *FileStore) GetKeyByHash(ctx context.Context, hash string) (*APIKey, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	keys, err := db.readKeys()
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		if key.Hash == hash {
+			return &key, nil
+		}
+	}
+	return nil, ErrKeyNotFound
+}
+
+func (db *FileStore) ListKeys(ctx context.Context) ([]APIKey, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	keys, err := db.readKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]APIKey, 0, len(keys))
+	for _, key := range keys {
+		list = append(list, key)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt < list[j].CreatedAt
+	})
+	return list, nil
+}
+
+func (db *FileStore) DeleteKey(ctx context.Context, id string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	keys, err := db.readKeys()
+	if err != nil {
+		return err
+	}
+	if _, ok := keys[id]; !ok {
+		return ErrKeyNotFound
+	}
+
+	delete(keys, id)
+	return db.writeKeys(keys)
+}
+
+func (db *FileStore) readKeys() (map[string]APIKey, error) {
+	b, err := os.ReadFile(filepath.Join(db.root, "apikeys.json"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return map[string]APIKey{}, nil
+		}
+		return nil, fmt.Errorf("failed to get keys: %w", err)
+	}
+
+	keys := map[string]APIKey{}
+	if err := json.Unmarshal(b, &keys); err != nil {
+		return nil, fmt.Errorf("failed to parse keys: %w", err)
+	}
+	return keys, nil
+}
+
+func (db *FileStore) writeKeys(keys map[string]APIKey) error {
+	b, err := json.Marshal(keys)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(db.root, "apikeys.json"), b); err != nil {
+		return fmt.Errorf("failed to save keys: %w", err)
+	}
+	return nil
+}
@@ -2,31 +2,97 @@ package database
 
 import (
 	"context"
-	"crypto/md5"
-	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/pardnchiu/go-faas/internal/utils"
 	"github.com/redis/go-redis/v9"
 )
 
+// * sorted set of every stored path, all score 0 for lexical range query
+const indexKey = "index:path"
+
 var (
-	DB Database
+	// * KEYS: meta, versions, seq, created, index, author, code, config
+	// * ARGV: path, language, code, now, config, author
+	// * seq starts from the highest stored version, old timestamp versions stay ordered
+	addScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[3]) == 0 then
+	local max = 0
+	for _, v in ipairs(redis.call('SMEMBERS', KEYS[2])) do
+		local n = tonumber(v)
+		if n and n > max then max = n end
+	end
+	redis.call('SET', KEYS[3], max)
+end
+local version = redis.call('INCR', KEYS[3])
+redis.call('HSET', KEYS[7], version, ARGV[3])
+if ARGV[5] ~= '' then redis.call('HSET', KEYS[8], version, ARGV[5]) end
+redis.call('SADD', KEYS[2], version)
+redis.call('HSET', KEYS[4], version, ARGV[4])
+if ARGV[6] ~= '' then redis.call('HSET', KEYS[6], version, ARGV[6]) end
+redis.call('HSET', KEYS[1], 'path', ARGV[1], 'language', ARGV[2], 'latest', version)
+redis.call('ZADD', KEYS[5], 0, ARGV[1])
+return version
+`)
+	// * KEYS: meta, versions; ARGV: field, value, version, canary
+	setAliasScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then return -1 end
+if redis.call('SISMEMBER', KEYS[2], ARGV[3]) == 0 then return 0 end
+if ARGV[4] ~= '0' and redis.call('SISMEMBER', KEYS[2], ARGV[4]) == 0 then return 0 end
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+return 1
+`)
+	// * KEYS: meta, versions; ARGV: version
+	setLatestScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then return -1 end
+if redis.call('SISMEMBER', KEYS[2], ARGV[1]) == 0 then return 0 end
+redis.call('HSET', KEYS[1], 'latest', ARGV[1])
+return 1
+`)
+	// * KEYS: meta, versions, code, created, config, author, legacy code, legacy config; ARGV: version
+	deleteVersionScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then return -1 end
+if redis.call('SISMEMBER', KEYS[2], ARGV[1]) == 0 then return 0 end
+if redis.call('HGET', KEYS[1], 'latest') == ARGV[1] then return -2 end
+local meta = redis.call('HGETALL', KEYS[1])
+for i = 1, #meta, 2 do
+	if string.sub(meta[i], 1, 6) == 'alias:' then
+		local v, c = string.match(meta[i + 1], '^(%d+),(%d+),')
+		if v == ARGV[1] or c == ARGV[1] then return -3 end
+	end
+end
+redis.call('SREM', KEYS[2], ARGV[1])
+redis.call('HDEL', KEYS[3], ARGV[1])
+redis.call('HDEL', KEYS[4], ARGV[1])
+redis.call('HDEL', KEYS[5], ARGV[1])
+redis.call('HDEL', KEYS[6], ARGV[1])
+redis.call('DEL', KEYS[7], KEYS[8])
+return 1
+`)
+	// * KEYS: index, meta, then every other key of the path; ARGV: path
+	// * seq is not passed, version ids are never reused when path is uploaded again
+	deleteScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[2]) == 0 then return -1 end
+for i = 2, #KEYS do redis.call('DEL', KEYS[i]) end
+redis.call('ZREM', KEYS[1], ARGV[1])
+return 1
+`)
 )
 
-type Database struct {
-	RDB *redis.Client
-}
+// * meta:<hash>:code   hash version => code
+// * meta:<hash>:config hash version => config json
+// * code:<hash>:<ver> and config:<hash>:<ver> are the legacy layout, still read and deleted
 
-type Script struct {
-	Path      string
-	Code      string
-	Language  string
-	Timestamp int64
+type RedisStore struct {
+	RDB *redis.Client
 }
 
-func Init() error {
+func NewRedisStore() (*RedisStore, error) {
 	// * initialize redis RDB with env
 	host := utils.GetWithDefault("REDIS_HOST", "localhost")
 	port := utils.GetWithDefaultInt("REDIS_PORT", 6379)
@@ -47,49 +113,130 @@ func Init() error {
 	defer cancel()
 
 	if err := rdb.Ping(ctx).Err(); err != nil {
-		return err
+		return nil, err
 	}
 
-	DB = Database{
+	db := &RedisStore{
 		RDB: rdb,
 	}
-	return nil
+
+	// * backfill index for scripts stored before index exist
+	indexCtx, indexCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer indexCancel()
+
+	if err := db.buildIndex(indexCtx); err != nil {
+		return nil, fmt.Errorf("failed to build index: %w", err)
+	}
+
+	return db, nil
 }
 
-func Close() error {
-	return DB.RDB.Close()
+func (db *RedisStore) buildIndex(ctx context.Context) error {
+	exist, err := db.RDB.Exists(ctx, indexKey).Result()
+	if err != nil {
+		return err
+	}
+	if exist > 0 {
+		return nil
+	}
+
+	iter := db.RDB.Scan(ctx, 0, "meta:*", 1000).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		// * skip meta:<hash>:version
+		if strings.Count(key, ":") != 1 {
+			continue
+		}
+		path, err := db.RDB.HGet(ctx, key, "path").Result()
+		if err != nil || path == "" {
+			continue
+		}
+		if err := db.RDB.ZAdd(ctx, indexKey, redis.Z{Member: path}).Err(); err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
+
+func (db *RedisStore) Close() error {
+	return db.RDB.Close()
 }
 
-func (db *Database) Add(ctx context.Context, script Script) (int64, error) {
-	hash := md5.Sum([]byte(script.Path))
-	hashStr := hex.EncodeToString(hash[:])
-	timestamp := time.Now().Unix()
+func (db *RedisStore) Add(ctx context.Context, script Script) (int64, error) {
+	hashStr := hashPath(script.Path)
+
+	var config string
+	if !script.Config.isZero() {
+		b, err := json.Marshal(script.Config)
+		if err != nil {
+			return 0, fmt.Errorf("failed to marshal config: %w", err)
+		}
+		config = string(b)
+	}
 
 	// * lang not same, can not overwrite
 	metaKey := fmt.Sprintf("meta:%s", hashStr)
-	codeKey := fmt.Sprintf("code:%s:%d", hashStr, timestamp)
-	versionsKey := fmt.Sprintf("%s:version", metaKey)
-	// * update meta
-	pipe := db.RDB.Pipeline()
-	pipe.HSet(ctx, metaKey, map[string]interface{}{
-		"path":     script.Path,
-		"language": script.Language,
-		"latest":   timestamp,
-	})
+	// * allocate version and update meta in one script, same second uploads never collide
+	version, err := addScript.Run(ctx, db.RDB, []string{
+		metaKey,
+		fmt.Sprintf("%s:version", metaKey),
+		fmt.Sprintf("%s:seq", metaKey),
+		fmt.Sprintf("%s:created", metaKey),
+		indexKey,
+		fmt.Sprintf("%s:author", metaKey),
+		fmt.Sprintf("%s:code", metaKey),
+		fmt.Sprintf("%s:config", metaKey),
+	},
+		script.Path,
+		script.Language,
+		script.Code,
+		time.Now().Unix(),
+		config,
+		script.Author,
+	).Int64()
+	if err != nil {
+		return 0, fmt.Errorf("failed to update meta: %w", err)
+	}
 
-	pipe.Set(ctx, codeKey, script.Code, 0)
-	pipe.SAdd(ctx, versionsKey, timestamp)
+	prune(ctx, db, script.Path)
 
-	if _, err := pipe.Exec(ctx); err != nil {
-		return 0, fmt.Errorf("failed to update meta: %w", err)
+	return version, nil
+}
+
+func (db *RedisStore) Get(ctx context.Context, path string, version int64) (*Script, error) {
+	hashStr := hashPath(path)
+
+	data, err := db.getMeta(ctx, hashStr)
+	if err != nil {
+		return nil, err
 	}
 
-	return timestamp, nil
+	if version == 0 {
+		version, _ = strconv.ParseInt(data["latest"], 10, 64)
+	}
+	return db.getCode(ctx, hashStr, data, version)
+}
+
+func (db *RedisStore) GetAlias(ctx context.Context, path, name string) (*Script, error) {
+	hashStr := hashPath(path)
+
+	data, err := db.getMeta(ctx, hashStr)
+	if err != nil {
+		return nil, err
+	}
+
+	value, ok := data["alias:"+name]
+	if !ok {
+		return nil, ErrAliasNotFound
+	}
+	alias, err := decodeAlias(name, value)
+	if err != nil {
+		return nil, err
+	}
+	return db.getCode(ctx, hashStr, data, alias.Resolve())
 }
 
-func (db *Database) Get(ctx context.Context, path string, version int64) (*Script, error) {
-	hash := md5.Sum([]byte(path))
-	hashStr := hex.EncodeToString(hash[:])
+func (db *RedisStore) getMeta(ctx context.Context, hashStr string) (map[string]string, error) {
 	metaKey := fmt.Sprintf("meta:%s", hashStr)
 
 	// * get meta
@@ -98,30 +245,531 @@ func (db *Database) Get(ctx context.Context, path string, version int64) (*Scrip
 		return nil, fmt.Errorf("failed to get meta: %w", err)
 	}
 	if len(data) == 0 {
-		return nil, fmt.Errorf("script not found")
+		return nil, ErrScriptNotFound
 	}
 
 	language := data["language"]
 	if language == "" {
 		return nil, fmt.Errorf("language not found in meta")
 	}
-	if version == 0 {
-		version, _ = db.RDB.HGet(ctx, metaKey, "latest").Int64()
+	return data, nil
+}
+
+func (db *RedisStore) getCode(ctx context.Context, hashStr string, data map[string]string, version int64) (*Script, error) {
+	metaKey := fmt.Sprintf("meta:%s", hashStr)
+	field := strconv.FormatInt(version, 10)
+
+	pipe := db.RDB.Pipeline()
+	codeCmd := pipe.HGet(ctx, fmt.Sprintf("%s:code", metaKey), field)
+	configCmd := pipe.HGet(ctx, fmt.Sprintf("%s:config", metaKey), field)
+	// * missing config is redis.Nil, checked by each cmd below
+	pipe.Exec(ctx)
+
+	// * version stored in the legacy layout
+	if codeCmd.Err() == redis.Nil {
+		pipe := db.RDB.Pipeline()
+		codeCmd = pipe.Get(ctx, fmt.Sprintf("code:%s:%d", hashStr, version))
+		configCmd = pipe.Get(ctx, fmt.Sprintf("config:%s:%d", hashStr, version))
+		pipe.Exec(ctx)
 	}
 
-	codeKey := fmt.Sprintf("code:%s:%d", hashStr, version)
-	code, err := db.RDB.Get(ctx, codeKey).Result()
+	code, err := codeCmd.Result()
 	if err != nil {
 		if err == redis.Nil {
-			return nil, fmt.Errorf("assign version not found")
+			return nil, ErrVersionNotFound
 		}
 		return nil, fmt.Errorf("failed to get script: %w", err)
 	}
 
+	var config Config
+	if raw, err := configCmd.Result(); err == nil {
+		if err := json.Unmarshal([]byte(raw), &config); err != nil {
+			return nil, fmt.Errorf("failed to parse config: %w", err)
+		}
+	} else if err != redis.Nil {
+		return nil, fmt.Errorf("failed to get config: %w", err)
+	}
+
 	return &Script{
 		Path:      data["path"],
 		Code:      code,
 		Language:  data["language"],
 		Timestamp: version,
+		Config:    config,
 	}, nil
 }
+
+func (db *RedisStore) List(ctx context.Context, opt ListOption) ([]Function, string, error) {
+	min := "-"
+	max := "+"
+	if opt.Prefix != "" {
+		min = "[" + opt.Prefix
+		max = "[" + opt.Prefix + "\xff"
+	}
+	if opt.Cursor != "" && opt.Cursor >= opt.Prefix {
+		min = "(" + opt.Cursor
+	}
+
+	list := []Function{}
+	for {
+		paths, err := db.RDB.ZRangeByLex(ctx, indexKey, &redis.ZRangeBy{
+			Min:   min,
+			Max:   max,
+			Count: int64(opt.Limit),
+		}).Result()
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to list index: %w", err)
+		}
+		if len(paths) == 0 {
+			break
+		}
+
+		pipe := db.RDB.Pipeline()
+		metaCmds := make([]*redis.MapStringStringCmd, len(paths))
+		countCmds := make([]*redis.IntCmd, len(paths))
+		for i, path := range paths {
+			metaKey := fmt.Sprintf("meta:%s", hashPath(path))
+			metaCmds[i] = pipe.HGetAll(ctx, metaKey)
+			countCmds[i] = pipe.SCard(ctx, fmt.Sprintf("%s:version", metaKey))
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return nil, "", fmt.Errorf("failed to get meta: %w", err)
+		}
+
+		for i, path := range paths {
+			min = "(" + path
+			if opt.Allow != nil && !opt.Allow(path) {
+				continue
+			}
+
+			data := metaCmds[i].Val()
+			// * index entry without meta, skip
+			if len(data) == 0 {
+				continue
+			}
+			if opt.Language != "" && data["language"] != opt.Language {
+				continue
+			}
+
+			latest, _ := strconv.ParseInt(data["latest"], 10, 64)
+			list = append(list, Function{
+				Path:     data["path"],
+				Language: data["language"],
+				Latest:   latest,
+				Versions: countCmds[i].Val(),
+			})
+			if len(list) == opt.Limit {
+				return list, path, nil
+			}
+		}
+
+		if len(paths) < opt.Limit {
+			break
+		}
+	}
+
+	return list, "", nil
+}
+
+func (db *RedisStore) Versions(ctx context.Context, path string) (*Function, []Version, error) {
+	hashStr := hashPath(path)
+	metaKey := fmt.Sprintf("meta:%s", hashStr)
+	versionsKey := fmt.Sprintf("%s:version", metaKey)
+
+	data, err := db.RDB.HGetAll(ctx, metaKey).Result()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get meta: %w", err)
+	}
+	if len(data) == 0 {
+		return nil, nil, ErrScriptNotFound
+	}
+
+	members, err := db.RDB.SMembers(ctx, versionsKey).Result()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get versions: %w", err)
+	}
+
+	versions := make([]int64, 0, len(members))
+	for _, m := range members {
+		v, err := strconv.ParseInt(m, 10, 64)
+		if err != nil {
+			continue
+		}
+		versions = append(versions, v)
+	}
+	// * newest first
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i] > versions[j]
+	})
+
+	pipe := db.RDB.Pipeline()
+	createdCmd := pipe.HGetAll(ctx, fmt.Sprintf("%s:created", metaKey))
+	authorCmd := pipe.HGetAll(ctx, fmt.Sprintf("%s:author", metaKey))
+	sizeCmds := make([]*redis.Cmd, len(versions))
+	legacyCmds := make([]*redis.IntCmd, len(versions))
+	for i, v := range versions {
+		sizeCmds[i] = pipe.Do(ctx, "HSTRLEN", fmt.Sprintf("%s:code", metaKey), v)
+		legacyCmds[i] = pipe.StrLen(ctx, fmt.Sprintf("code:%s:%d", hashStr, v))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, nil, fmt.Errorf("failed to get code size: %w", err)
+	}
+
+	created := createdCmd.Val()
+	author := authorCmd.Val()
+	list := make([]Version, len(versions))
+	for i, v := range versions {
+		// * timestamp versions stored before seq were their own upload time
+		createdAt, err := strconv.ParseInt(created[strconv.FormatInt(v, 10)], 10, 64)
+		if err != nil {
+			createdAt = v
+		}
+		size, _ := sizeCmds[i].Int64()
+		list[i] = Version{
+			Version:   v,
+			Size:      max(size, legacyCmds[i].Val()),
+			CreatedAt: createdAt,
+			Author:    author[strconv.FormatInt(v, 10)],
+		}
+	}
+
+	latest, _ := strconv.ParseInt(data["latest"], 10, 64)
+	return &Function{
+		Path:     data["path"],
+		Language: data["language"],
+		Latest:   latest,
+		Versions: int64(len(list)),
+	}, list, nil
+}
+
+func (db *RedisStore) Delete(ctx context.Context, path string) error {
+	hashStr := hashPath(path)
+	metaKey := fmt.Sprintf("meta:%s", hashStr)
+	versionsKey := fmt.Sprintf("%s:version", metaKey)
+
+	// * legacy keys are never written again, a concurrent add can not race with them
+	members, err := db.RDB.SMembers(ctx, versionsKey).Result()
+	if err != nil {
+		return fmt.Errorf("failed to get versions: %w", err)
+	}
+
+	keys := []string{
+		indexKey,
+		metaKey,
+		versionsKey,
+		fmt.Sprintf("%s:created", metaKey),
+		fmt.Sprintf("%s:author", metaKey),
+		fmt.Sprintf("%s:code", metaKey),
+		fmt.Sprintf("%s:config", metaKey),
+	}
+	for _, m := range members {
+		keys = append(keys,
+			fmt.Sprintf("code:%s:%s", hashStr, m),
+			fmt.Sprintf("config:%s:%s", hashStr, m),
+		)
+	}
+
+	res, err := deleteScript.Run(ctx, db.RDB, keys, path).Int()
+	if err != nil {
+		return fmt.Errorf("failed to delete script: %w", err)
+	}
+	return scriptResult(res)
+}
+
+func (db *RedisStore) DeleteVersion(ctx context.Context, path string, version int64) error {
+	hashStr := hashPath(path)
+	metaKey := fmt.Sprintf("meta:%s", hashStr)
+
+	res, err := deleteVersionScript.Run(ctx, db.RDB, []string{
+		metaKey,
+		fmt.Sprintf("%s:version", metaKey),
+		fmt.Sprintf("%s:code", metaKey),
+		fmt.Sprintf("%s:created", metaKey),
+		fmt.Sprintf("%s:config", metaKey),
+		fmt.Sprintf("%s:author", metaKey),
+		fmt.Sprintf("code:%s:%d", hashStr, version),
+		fmt.Sprintf("config:%s:%d", hashStr, version),
+	}, version).Int()
+	if err != nil {
+		return fmt.Errorf("failed to delete version: %w", err)
+	}
+	return scriptResult(res)
+}
+
+func (db *RedisStore) SetLatest(ctx context.Context, path string, version int64) error {
+	metaKey := fmt.Sprintf("meta:%s", hashPath(path))
+
+	res, err := setLatestScript.Run(ctx, db.RDB, []string{
+		metaKey,
+		fmt.Sprintf("%s:version", metaKey),
+	}, version).Int()
+	if err != nil {
+		return fmt.Errorf("failed to set latest: %w", err)
+	}
+	return scriptResult(res)
+}
+
+func scriptResult(res int) error {
+	switch res {
+	case -1:
+		return ErrScriptNotFound
+	case -2:
+		return ErrVersionInUse
+	case -3:
+		return ErrVersionAliased
+	case 0:
+		return ErrVersionNotFound
+	default:
+		return nil
+	}
+}
+
+func (db *RedisStore) Aliases(ctx context.Context, path string) ([]Alias, error) {
+	data, err := db.getMeta(ctx, hashPath(path))
+	if err != nil {
+		return nil, err
+	}
+
+	list := []Alias{}
+	for field, value := range data {
+		name, ok := strings.CutPrefix(field, "alias:")
+		if !ok {
+			continue
+		}
+		alias, err := decodeAlias(name, value)
+		if err != nil {
+			continue
+		}
+		list = append(list, alias)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list, nil
+}
+
+func (db *RedisStore) SetAlias(ctx context.Context, path string, alias Alias) error {
+	metaKey := fmt.Sprintf("meta:%s", hashPath(path))
+
+	res, err := setAliasScript.Run(ctx, db.RDB, []string{
+		metaKey,
+		fmt.Sprintf("%s:version", metaKey),
+	}, "alias:"+alias.Name, alias.encode(), alias.Version, alias.Canary).Int()
+	if err != nil {
+		return fmt.Errorf("failed to set alias: %w", err)
+	}
+	return scriptResult(res)
+}
+
+func (db *RedisStore) DeleteAlias(ctx context.Context, path, name string) error {
+	metaKey := fmt.Sprintf("meta:%s", hashPath(path))
+
+	n, err := db.RDB.HDel(ctx, metaKey, "alias:"+name).Result()
+	if err != nil {
+		return fmt.Errorf("failed to delete alias: %w", err)
+	}
+	if n > 0 {
+		return nil
+	}
+
+	exist, err := db.RDB.Exists(ctx, metaKey).Result()
+	if err != nil {
+		return fmt.Errorf("failed to get meta: %w", err)
+	}
+	if exist == 0 {
+		return ErrScriptNotFound
+	}
+	return ErrAliasNotFound
+}
+
+// * schedules:        hash id => schedule json
+// * schedule:<id>:last  => last run json
+// * schedule:<id>:<at>  => fire claim, expire after one hour
+func (db *RedisStore) SaveSchedule(ctx context.Context, schedule Schedule) error {
+	schedule.LastRun = nil
+	b, err := json.Marshal(schedule)
+	if err != nil {
+		return fmt.Errorf("failed to marshal schedule: %w", err)
+	}
+	if err := db.RDB.HSet(ctx, "schedules", schedule.ID, b).Err(); err != nil {
+		return fmt.Errorf("failed to save schedule: %w", err)
+	}
+	return nil
+}
+
+func (db *RedisStore) GetSchedule(ctx context.Context, id string) (*Schedule, error) {
+	pipe := db.RDB.Pipeline()
+	defCmd := pipe.HGet(ctx, "schedules", id)
+	lastCmd := pipe.Get(ctx, fmt.Sprintf("schedule:%s:last", id))
+	pipe.Exec(ctx)
+
+	raw, err := defCmd.Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, ErrScheduleNotFound
+		}
+		return nil, fmt.Errorf("failed to get schedule: %w", err)
+	}
+	return decodeSchedule(raw, lastCmd.Val())
+}
+
+func (db *RedisStore) ListSchedules(ctx context.Context) ([]Schedule, error) {
+	data, err := db.RDB.HGetAll(ctx, "schedules").Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list schedules: %w", err)
+	}
+
+	ids := make([]string, 0, len(data))
+	for id := range data {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	pipe := db.RDB.Pipeline()
+	lastCmds := make([]*redis.StringCmd, len(ids))
+	for i, id := range ids {
+		lastCmds[i] = pipe.Get(ctx, fmt.Sprintf("schedule:%s:last", id))
+	}
+	pipe.Exec(ctx)
+
+	list := make([]Schedule, 0, len(ids))
+	for i, id := range ids {
+		schedule, err := decodeSchedule(data[id], lastCmds[i].Val())
+		if err != nil {
+			continue
+		}
+		list = append(list, *schedule)
+	}
+	return list, nil
+}
+
+func (db *RedisStore) DeleteSchedule(ctx context.Context, id string) error {
+	pipe := db.RDB.TxPipeline()
+	delCmd := pipe.HDel(ctx, "schedules", id)
+	pipe.Del(ctx, fmt.Sprintf("schedule:%s:last", id))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to delete schedule: %w", err)
+	}
+	if delCmd.Val() == 0 {
+		return ErrScheduleNotFound
+	}
+	return nil
+}
+
+func (db *RedisStore) ClaimSchedule(ctx context.Context, id string, at int64) (bool, error) {
+	key := fmt.Sprintf("schedule:%s:%d", id, at)
+	ok, err := db.RDB.SetNX(ctx, key, 1, time.Hour).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to claim schedule: %w", err)
+	}
+	return ok, nil
+}
+
+func (db *RedisStore) SetScheduleRun(ctx context.Context, id string, run ScheduleRun) error {
+	b, err := json.Marshal(run)
+	if err != nil {
+		return fmt.Errorf("failed to marshal schedule run: %w", err)
+	}
+	if err := db.RDB.Set(ctx, fmt.Sprintf("schedule:%s:last", id), b, 0).Err(); err != nil {
+		return fmt.Errorf("failed to save schedule run: %w", err)
+	}
+	return nil
+}
+
+func decodeSchedule(raw, last string) (*Schedule, error) {
+	var schedule Schedule
+	if err := json.Unmarshal([]byte(raw), &schedule); err != nil {
+		return nil, fmt.Errorf("failed to parse schedule: %w", err)
+	}
+	if last != "" {
+		var run ScheduleRun
+		if err := json.Unmarshal([]byte(last), &run); err == nil {
+			schedule.LastRun = &run
+		}
+	}
+	return &schedule, nil
+}
+
+// * apikeys:      hash id => key json
+// * apikey:hash:  hash sha-256 => id
+func (db *RedisStore) SaveKey(ctx context.Context, key APIKey) error {
+	b, err := json.Marshal(key)
+	if err != nil {
+		return fmt.Errorf("failed to marshal key: %w", err)
+	}
+
+	pipe := db.RDB.TxPipeline()
+	pipe.HSet(ctx, "apikeys", key.ID, b)
+	pipe.HSet(ctx, "apikey:hash", key.Hash, key.ID)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to save key: %w", err)
+	}
+	return nil
+}
+
+func (db *RedisStore) GetKeyByHash(ctx context.Context, hash string) (*APIKey, error) {
+	id, err := db.RDB.HGet(ctx, "apikey:hash", hash).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, ErrKeyNotFound
+		}
+		return nil, fmt.Errorf("failed to get key: %w", err)
+	}
+
+	raw, err := db.RDB.HGet(ctx, "apikeys", id).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, ErrKeyNotFound
+		}
+		return nil, fmt.Errorf("failed to get key: %w", err)
+	}
+
+	var key APIKey
+	if err := json.Unmarshal([]byte(raw), &key); err != nil {
+		return nil, fmt.Errorf("failed to parse key: %w", err)
+	}
+	return &key, nil
+}
+
+func (db *RedisStore) ListKeys(ctx context.Context) ([]APIKey, error) {
+	data, err := db.RDB.HGetAll(ctx, "apikeys").Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list keys: %w", err)
+	}
+
+	list := make([]APIKey, 0, len(data))
+	for _, raw := range data {
+		var key APIKey
+		if err := json.Unmarshal([]byte(raw), &key); err != nil {
+			continue
+		}
+		list = append(list, key)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt < list[j].CreatedAt
+	})
+	return list, nil
+}
+
+func (db *RedisStore) DeleteKey(ctx context.Context, id string) error {
+	raw, err := db.RDB.HGet(ctx, "apikeys", id).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return ErrKeyNotFound
+		}
+		return fmt.Errorf("failed to get key: %w", err)
+	}
+
+	var key APIKey
+	if err := json.Unmarshal([]byte(raw), &key); err != nil {
+		return fmt.Errorf("failed to parse key: %w", err)
+	}
+
+	pipe := db.RDB.TxPipeline()
+	pipe.HDel(ctx, "apikeys", id)
+	pipe.HDel(ctx, "apikey:hash", key.Hash)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to delete key: %w", err)
+	}
+	return nil
+}
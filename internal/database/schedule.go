@@ -0,0 +1,40 @@
+package database
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	ErrScheduleNotFound = errors.New("schedule not found")
+)
+
+type ScheduleStore interface {
+	SaveSchedule(ctx context.Context, schedule Schedule) error
+	GetSchedule(ctx context.Context, id string) (*Schedule, error)
+	ListSchedules(ctx context.Context) ([]Schedule, error)
+	DeleteSchedule(ctx context.Context, id string) error
+	// * true only for the first instance claiming this fire time
+	ClaimSchedule(ctx context.Context, id string, at int64) (bool, error)
+	SetScheduleRun(ctx context.Context, id string, run ScheduleRun) error
+}
+
+type Schedule struct {
+	ID        string       `json:"id"`
+	Cron      string       `json:"cron"`
+	Path      string       `json:"path"`
+	Version   int64        `json:"version,omitempty"`
+	Alias     string       `json:"alias,omitempty"`
+	Input     string       `json:"input,omitempty"`
+	Subject   string       `json:"subject,omitempty"`
+	CreatedAt int64        `json:"created_at"`
+	LastRun   *ScheduleRun `json:"last_run,omitempty"`
+}
+
+type ScheduleRun struct {
+	At       int64  `json:"at"`
+	Status   string `json:"status"`
+	Version  int64  `json:"version,omitempty"`
+	Duration int64  `json:"duration_ms"`
+	Error    string `json:"error,omitempty"`
+}
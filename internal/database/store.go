@@ -0,0 +1,181 @@
+package database
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/pardnchiu/go-faas/internal/utils"
+)
+
+var (
+	DB ScriptStore
+
+	ErrScriptNotFound  = errors.New("script not found")
+	ErrVersionNotFound = errors.New("assign version not found")
+	ErrVersionInUse    = errors.New("version is latest, rollback before delete")
+	ErrVersionAliased  = errors.New("version is referenced by alias")
+	ErrAliasNotFound   = errors.New("alias not found")
+
+	retention Retention
+)
+
+type ScriptStore interface {
+	Add(ctx context.Context, script Script) (int64, error)
+	Get(ctx context.Context, path string, version int64) (*Script, error)
+	List(ctx context.Context, opt ListOption) ([]Function, string, error)
+	Versions(ctx context.Context, path string) (*Function, []Version, error)
+	Delete(ctx context.Context, path string) error
+	DeleteVersion(ctx context.Context, path string, version int64) error
+	SetLatest(ctx context.Context, path string, version int64) error
+	GetAlias(ctx context.Context, path, name string) (*Script, error)
+	Aliases(ctx context.Context, path string) ([]Alias, error)
+	SetAlias(ctx context.Context, path string, alias Alias) error
+	DeleteAlias(ctx context.Context, path, name string) error
+	ScheduleStore
+	KeyStore
+	Close() error
+}
+
+type Script struct {
+	Path      string
+	Code      string
+	Language  string
+	Timestamp int64
+	Config    Config
+	Author    string
+}
+
+type Function struct {
+	Path     string `json:"path"`
+	Language string `json:"language"`
+	Latest   int64  `json:"latest"`
+	Versions int64  `json:"versions"`
+}
+
+type Version struct {
+	Version   int64  `json:"version"`
+	Size      int64  `json:"size"`
+	CreatedAt int64  `json:"created_at"`
+	Author    string `json:"author,omitempty"`
+}
+
+// * Cursor is the last path of previous page, empty for first page
+type ListOption struct {
+	Prefix   string
+	Language string
+	Cursor   string
+	Limit    int
+	// * skipped before the limit, page and cursor never hold a denied path; nil allows all
+	Allow func(path string) bool
+}
+
+// * zero value means no limit, latest and aliased versions are always kept
+type Retention struct {
+	Keep   int
+	MaxAge time.Duration
+}
+
+func Init() error {
+	retention = Retention{
+		Keep:   utils.GetWithDefaultInt("RETAIN_VERSIONS", 0),
+		MaxAge: time.Duration(utils.GetWithDefaultInt("RETAIN_MAX_AGE_HOURS", 0)) * time.Hour,
+	}
+
+	// * select storage backend with env, redis by default
+	driver := utils.GetWithDefault("STORE_DRIVER", "redis")
+
+	var store ScriptStore
+	var err error
+	switch driver {
+	case "redis":
+		store, err = NewRedisStore()
+	case "file":
+		store, err = NewFileStore(utils.GetWithDefault("STORE_PATH", "./data"))
+	default:
+		return fmt.Errorf("unsupported store driver: %s", driver)
+	}
+	if err != nil {
+		return err
+	}
+
+	DB = store
+	return nil
+}
+
+func Close() error {
+	if DB == nil {
+		return nil
+	}
+	return DB.Close()
+}
+
+func hashPath(path string) string {
+	hash := md5.Sum([]byte(path))
+	return hex.EncodeToString(hash[:])
+}
+
+// * versions to drop under policy, list must be newest first
+func (r Retention) expired(list []Version, pinned map[int64]bool, now time.Time) []int64 {
+	var drop []int64
+	for i, v := range list {
+		if pinned[v.Version] {
+			continue
+		}
+		if r.Keep > 0 && i >= r.Keep {
+			drop = append(drop, v.Version)
+			continue
+		}
+		if r.MaxAge > 0 && now.Sub(time.Unix(v.CreatedAt, 0)) > r.MaxAge {
+			drop = append(drop, v.Version)
+		}
+	}
+	return drop
+}
+
+// * enforce retention after upload, failure only logged
+func prune(ctx context.Context, store ScriptStore, path string) {
+	if retention.Keep <= 0 && retention.MaxAge <= 0 {
+		return
+	}
+
+	fn, list, err := store.Versions(ctx, path)
+	if err != nil {
+		slog.Warn("failed to prune versions",
+			slog.String("path", path),
+			slog.String("error", err.Error()),
+		)
+		return
+	}
+
+	aliases, err := store.Aliases(ctx, path)
+	if err != nil {
+		slog.Warn("failed to prune versions",
+			slog.String("path", path),
+			slog.String("error", err.Error()),
+		)
+		return
+	}
+
+	// * latest and aliased versions are never pruned
+	pinned := map[int64]bool{fn.Latest: true}
+	for _, a := range aliases {
+		for _, v := range a.pinned() {
+			pinned[v] = true
+		}
+	}
+
+	for _, v := range retention.expired(list, pinned, time.Now()) {
+		if err := store.DeleteVersion(ctx, path, v); err != nil {
+			slog.Warn("failed to prune version",
+				slog.String("path", path),
+				slog.Int64("version", v),
+				slog.String("error", err.Error()),
+			)
+		}
+	}
+}
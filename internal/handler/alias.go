@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pardnchiu/go-faas/internal/database"
+)
+
+type AliasBody struct {
+	Version int64 `json:"version" binding:"required"`
+	Canary  int64 `json:"canary"`
+	Weight  int   `json:"weight"`
+}
+
+func listAliases(c *gin.Context, path string) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutRedis)
+	defer cancel()
+
+	list, err := database.DB.Aliases(ctx, path)
+	if err != nil {
+		sendStoreError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"path": path,
+		"data": list,
+	})
+}
+
+func setAlias(c *gin.Context, path, name string) {
+	var body AliasBody
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.String(http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	alias := database.Alias{
+		Name:    name,
+		Version: body.Version,
+		Canary:  body.Canary,
+		Weight:  body.Weight,
+	}
+	if err := alias.Validate(); err != nil {
+		c.String(http.StatusBadRequest, "bad request: "+err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutRedis)
+	defer cancel()
+
+	if err := database.DB.SetAlias(ctx, path, alias); err != nil {
+		sendStoreError(c, err)
+		return
+	}
+
+	slog.Info("set alias",
+		slog.String("path", path),
+		slog.String("alias", name),
+		slog.Int64("version", alias.Version),
+		slog.Int64("canary", alias.Canary),
+		slog.Int("weight", alias.Weight),
+	)
+	c.JSON(http.StatusOK, alias)
+}
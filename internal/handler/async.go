@@ -0,0 +1,199 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pardnchiu/go-faas/internal/admission"
+	"github.com/pardnchiu/go-faas/internal/auth"
+	"github.com/pardnchiu/go-faas/internal/database"
+	"github.com/pardnchiu/go-faas/internal/queue"
+)
+
+// * run options kept with an async job, applied by the worker
+type jobOptions struct {
+	Limits       database.Limits `json:"limits"`
+	FailOnStderr *bool           `json:"fail_on_stderr,omitempty"`
+	Envelope     bool            `json:"envelope,omitempty"`
+}
+
+func RunAsync(c *gin.Context) {
+	targetPath := strings.TrimPrefix(c.Param("targetPath"), "/")
+
+	version, alias, err := getRunTarget(c)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	body, err := getRunBody(c)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutRedis)
+	defer cancel()
+
+	// * reject missing function before enqueue
+	if _, err := getScript(ctx, targetPath, version, alias); err != nil {
+		c.String(http.StatusNotFound,
+			fmt.Sprintf("bad request: %s", err.Error()),
+		)
+		return
+	}
+
+	options, err := json.Marshal(jobOptions{
+		Limits:       body.Limits,
+		FailOnStderr: body.FailOnStderr,
+		Envelope:     body.Envelope,
+	})
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Failed to enqueue job")
+		return
+	}
+
+	job := &queue.Job{
+		Path:    targetPath,
+		Version: version,
+		Alias:   alias,
+		Subject: auth.Subject(c),
+		Options: options,
+		Input:   string(body.Input),
+	}
+	if err := queue.Enqueue(ctx, job); err != nil {
+		if errors.Is(err, queue.ErrDisabled) {
+			c.String(http.StatusServiceUnavailable, err.Error())
+			return
+		}
+		slog.Error("failed to enqueue job",
+			slog.String("error", err.Error()),
+		)
+		c.String(http.StatusInternalServerError, "Failed to enqueue job")
+		return
+	}
+
+	slog.Info("run-async request",
+		"job_id", job.ID,
+		"script_path", targetPath,
+		"script_version", version,
+		"script_alias", alias)
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"id":     job.ID,
+		"status": job.Status,
+	})
+}
+
+func GetJob(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutRedis)
+	defer cancel()
+
+	job, err := queue.Get(ctx, c.Param("id"))
+	if err != nil {
+		switch {
+		case errors.Is(err, queue.ErrJobNotFound):
+			c.String(http.StatusNotFound, err.Error())
+		case errors.Is(err, queue.ErrDisabled):
+			c.String(http.StatusServiceUnavailable, err.Error())
+		default:
+			slog.Error("failed to get job",
+				slog.String("error", err.Error()),
+			)
+			c.String(http.StatusInternalServerError, "Failed to get job")
+		}
+		return
+	}
+	if !auth.AllowPath(c, job.Path) {
+		c.String(http.StatusForbidden, "forbidden: path not allowed")
+		return
+	}
+
+	res := gin.H{
+		"job": job,
+	}
+	// * failed envelope job still carries its envelope
+	if job.Status == queue.StatusSucceeded || job.Output != "" {
+		res["data"], res["type"] = parseOutput(job.Output)
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// * queue executor, same path as /run without http
+func RunJob(job *queue.Job) (string, int64, error) {
+	slog.Info("run job", "job_id", job.ID)
+
+	var options jobOptions
+	if len(job.Options) > 0 {
+		if err := json.Unmarshal(job.Options, &options); err != nil {
+			return "", job.Version, fmt.Errorf("invalid job options: %w", err)
+		}
+	}
+
+	output, version, err := runStored(job.Path, job.Version, job.Alias, &RunBody{
+		Input:        RunInput(job.Input),
+		Limits:       options.Limits,
+		FailOnStderr: options.FailOnStderr,
+		Envelope:     options.Envelope,
+		Subject:      job.Subject,
+	})
+	// * no slot in time is not a job failure, run it later
+	if err != nil && errorCode(err) == errOverloaded {
+		return "", version, &queue.RequeueError{
+			Delay: time.Duration(admission.RetryAfter()) * time.Second,
+			Err:   err,
+		}
+	}
+	return output, version, err
+}
+
+// * body carries input and options, code and config come from the stored version
+func runStored(path string, version int64, alias string, body *RunBody) (string, int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutRedis)
+	defer cancel()
+
+	script, err := getScript(ctx, path, version, alias)
+	if err != nil {
+		return "", 0, err
+	}
+
+	slog.Info("run stored script",
+		"script_path", path,
+		"script_version", script.Timestamp,
+		"script_alias", alias)
+
+	body.Code = script.Code
+	body.Language = script.Language
+	body.Config = script.Config
+	body.Path = path
+	body.Version = script.Timestamp
+
+	release, err := body.admit(context.Background())
+	if err != nil {
+		return "", script.Timestamp, err
+	}
+	defer release()
+
+	// * envelope is kept as output even when the run failed
+	if body.Envelope {
+		res, err := execScript(context.Background(), body)
+		if err != nil {
+			return "", script.Timestamp, err
+		}
+		b, err := json.Marshal(newEnvelope(body, res))
+		if err != nil {
+			return "", script.Timestamp, fmt.Errorf("failed to marshal envelope: %w", err)
+		}
+		return string(b), script.Timestamp, res.Err
+	}
+
+	output, err := runScript(context.Background(), body)
+	return output, script.Timestamp, err
+}
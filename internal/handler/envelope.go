@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"strings"
+
+	"github.com/pardnchiu/go-faas/internal/sandbox"
+)
+
+type Envelope struct {
+	Result   any           `json:"result,omitempty"`
+	Type     string        `json:"type,omitempty"`
+	Stdout   []string      `json:"stdout"`
+	Stderr   string        `json:"stderr"`
+	ExitCode int           `json:"exit_code"`
+	Error    string        `json:"error,omitempty"`
+	Code     ErrorCode     `json:"error_code,omitempty"`
+	Duration int64         `json:"duration_ms"`
+	Startup  int64         `json:"startup_ms"`
+	Usage    sandbox.Usage `json:"usage"`
+	Version  int64         `json:"version,omitempty"`
+}
+
+// * full invocation detail, failed runs still report logs and exit code
+func newEnvelope(body *RunBody, res *execResult) Envelope {
+	env := Envelope{
+		Stdout:   []string{},
+		Stderr:   res.Stderr,
+		ExitCode: res.ExitCode,
+		Duration: res.Duration.Milliseconds(),
+		Startup:  res.Startup.Milliseconds(),
+		Usage:    res.Usage,
+		Version:  body.Version,
+	}
+
+	if raw := strings.TrimRight(res.Stdout, "\n"); raw != "" {
+		env.Stdout = strings.Split(raw, "\n")
+	}
+
+	if res.Err != nil {
+		env.Error = res.Err.Error()
+		env.Code = errorCode(res.Err)
+		return env
+	}
+	env.Result, env.Type = parseOutput(res.Result)
+	return env
+}
@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pardnchiu/go-faas/internal/admission"
+	"github.com/pardnchiu/go-faas/internal/sandbox"
+)
+
+type ErrorCode string
+
+const (
+	errTimeout       ErrorCode = "timeout"
+	errOOMKilled     ErrorCode = "oom_killed"
+	errCPULimit      ErrorCode = "cpu_limit"
+	errUserException ErrorCode = "user_exception"
+	errSandbox       ErrorCode = "sandbox_error"
+	errNotFound      ErrorCode = "not_found"
+	errInvalidInput  ErrorCode = "invalid_input"
+	errOverloaded    ErrorCode = "overloaded"
+	errCancelled     ErrorCode = "cancelled"
+)
+
+var errorStatus = map[ErrorCode]int{
+	errInvalidInput:  http.StatusBadRequest,
+	errNotFound:      http.StatusNotFound,
+	errOverloaded:    http.StatusTooManyRequests,
+	errCancelled:     http.StatusConflict,
+	errUserException: http.StatusUnprocessableEntity,
+	errSandbox:       http.StatusInternalServerError,
+	errCPULimit:      http.StatusServiceUnavailable,
+	errTimeout:       http.StatusGatewayTimeout,
+	errOOMKilled:     http.StatusInsufficientStorage,
+}
+
+// * classified failure, code decides http status
+type RunError struct {
+	Code ErrorCode
+	Err  error
+}
+
+func (e *RunError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RunError) Unwrap() error {
+	return e.Err
+}
+
+func newRunError(code ErrorCode, err error) *RunError {
+	return &RunError{Code: code, Err: err}
+}
+
+// * unclassified error is sandbox error
+func errorCode(err error) ErrorCode {
+	var runErr *RunError
+	if errors.As(err, &runErr) {
+		return runErr.Code
+	}
+	return errSandbox
+}
+
+func errorBody(err error) gin.H {
+	return gin.H{
+		"error":   errorCode(err),
+		"message": err.Error(),
+	}
+}
+
+func sendError(c *gin.Context, err error) {
+	code := errorCode(err)
+	if code == errOverloaded {
+		c.Header("Retry-After", strconv.Itoa(admission.RetryAfter()))
+	}
+	c.JSON(errorStatus[code], errorBody(err))
+}
+
+// * stop started by the server, empty when the process ended on its own
+func stopCause(ctx context.Context) ErrorCode {
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		return errTimeout
+	case context.Canceled:
+		return errCancelled
+	}
+	return ""
+}
+
+// * exit status and scope memory.events, called only for failed runs
+// * stopped is set when the server ended the run, its kill is not an oom
+func classifyExit(state *os.ProcessState, stopped ErrorCode, usage sandbox.Usage, limit sandbox.Limit, duration time.Duration) ErrorCode {
+	// * throttled for most of the run, quota too small rather than slow code
+	throttled := time.Duration(usage.ThrottledTime)*time.Microsecond*2 >= duration
+
+	switch {
+	case usage.OOMKills > 0:
+		return errOOMKilled
+	case stopped == errTimeout && usage.Throttled > 0 && throttled:
+		return errCPULimit
+	case stopped != "":
+		return stopped
+	case state == nil:
+		return errSandbox
+	}
+
+	signal, signaled := exitSignal(state)
+
+	// * killed at the memory ceiling, oom_kill counter missing on older kernels
+	// * bwrap reports a killed child as exit status 128 + signal
+	killed := signal == syscall.SIGKILL || (!signaled && state.ExitCode() == 128+int(syscall.SIGKILL))
+	if memory, ok := limit.MemoryMax(); ok && killed && usage.MemoryPeak >= memory {
+		return errOOMKilled
+	}
+
+	switch {
+	case signal == syscall.SIGXCPU:
+		return errCPULimit
+	case signaled:
+		// * SIGKILL without oom evidence comes from terminate or the reaper
+		return errSandbox
+	case state.ExitCode() > 0:
+		return errUserException
+	}
+	return errSandbox
+}
+
+// * signal that killed the process itself, exit status of bwrap is not a signal
+func exitSignal(state *os.ProcessState) (syscall.Signal, bool) {
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return status.Signal(), true
+	}
+	return 0, false
+}
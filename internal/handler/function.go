@@ -0,0 +1,338 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pardnchiu/go-faas/internal/auth"
+	"github.com/pardnchiu/go-faas/internal/database"
+	"github.com/pardnchiu/go-faas/internal/utils"
+)
+
+const (
+	listDefaultLimit = 50
+	listMaxLimit     = 200
+)
+
+// * trailing segments read as actions, rejected inside a function path on upload
+var functionActions = []string{"versions", "aliases", "diff", "rollback"}
+
+func ListFunctions(c *gin.Context) {
+	language := c.Query("language")
+	if language != "" {
+		if _, ok := runtimeMap[language]; !ok {
+			c.String(http.StatusBadRequest,
+				"bad request: unsupported language",
+			)
+			return
+		}
+	}
+
+	// * limit invalid, use default
+	limit := listDefaultLimit
+	if v, err := strconv.Atoi(c.Query("limit")); err == nil && v > 0 {
+		limit = min(v, listMaxLimit)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutRedis)
+	defer cancel()
+
+	// * restricted caller pages only inside its own prefix
+	prefix, ok := clampPrefix(c.Query("prefix"), auth.AllowedPaths(c))
+	if !ok {
+		c.JSON(http.StatusOK, gin.H{
+			"data": []database.Function{},
+			"next": "",
+		})
+		return
+	}
+
+	list, next, err := database.DB.List(ctx, database.ListOption{
+		Prefix:   prefix,
+		Language: language,
+		Cursor:   c.Query("cursor"),
+		Limit:    limit,
+		Allow: func(path string) bool {
+			return auth.AllowPath(c, path)
+		},
+	})
+	if err != nil {
+		slog.Error("failed to list functions",
+			slog.String("error", err.Error()),
+		)
+		c.String(http.StatusInternalServerError, "Failed to list functions")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": list,
+		"next": next,
+	})
+}
+
+// * narrow requested prefix to a single granted prefix, false when they do not overlap
+// * several grants keep the request prefix, the store filter still applies
+func clampPrefix(prefix string, allowed []string) (string, bool) {
+	// * empty grant list reaches no function
+	if allowed != nil && len(allowed) == 0 {
+		return "", false
+	}
+	if len(allowed) != 1 {
+		return prefix, true
+	}
+
+	grant := strings.Trim(allowed[0], "/")
+	prefix = strings.TrimPrefix(prefix, "/")
+	switch {
+	case grant == "" || strings.HasPrefix(prefix, grant):
+		return prefix, true
+	case strings.HasPrefix(grant, prefix):
+		return grant, true
+	}
+	return "", false
+}
+
+// * split catch-all param into function path, trailing action and key
+// * "<path>/versions" => versions, "<path>/versions/<v>" => versions + v
+// * "<path>/aliases" => aliases, "<path>/aliases/<name>" => aliases + name
+// * "<path>/diff" => diff, "<path>/rollback" => rollback
+func parseFunctionPath(raw string) (path, action, key string) {
+	raw = strings.TrimPrefix(raw, "/")
+
+	for _, act := range []string{"versions", "aliases"} {
+		if i := strings.LastIndex(raw, "/"+act+"/"); i > 0 {
+			key := raw[i+len(act)+2:]
+			if key != "" && !strings.Contains(key, "/") {
+				return raw[:i], act, key
+			}
+		}
+	}
+
+	for _, act := range functionActions {
+		if p, ok := strings.CutSuffix(raw, "/"+act); ok && p != "" {
+			return p, act, ""
+		}
+	}
+	return raw, "", ""
+}
+
+// * any segment named after an action would be misread by parseFunctionPath
+func hasReservedSegment(path string) bool {
+	for _, seg := range strings.Split(strings.Trim(path, "/"), "/") {
+		if slices.Contains(functionActions, seg) {
+			return true
+		}
+	}
+	return false
+}
+
+func GetFunction(c *gin.Context) {
+	path, action, key := parseFunctionPath(c.Param("targetPath"))
+
+	switch {
+	case action == "versions" && key == "":
+		listVersions(c, path)
+	case action == "versions":
+		version, err := strconv.ParseInt(key, 10, 64)
+		if err != nil {
+			c.String(http.StatusBadRequest, "bad request: invalid version")
+			return
+		}
+		getVersion(c, path, version)
+	case action == "aliases" && key == "":
+		listAliases(c, path)
+	case action == "diff":
+		diffVersions(c, path)
+	default:
+		c.String(http.StatusNotFound, "not found")
+	}
+}
+
+func PutFunction(c *gin.Context) {
+	path, action, key := parseFunctionPath(c.Param("targetPath"))
+	if action != "aliases" || key == "" {
+		c.String(http.StatusNotFound, "not found")
+		return
+	}
+	setAlias(c, path, key)
+}
+
+type RollbackBody struct {
+	Version int64 `json:"version" binding:"required"`
+}
+
+func PostFunction(c *gin.Context) {
+	path, action, _ := parseFunctionPath(c.Param("targetPath"))
+	if action != "rollback" {
+		c.String(http.StatusNotFound, "not found")
+		return
+	}
+
+	var body RollbackBody
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.String(http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutRedis)
+	defer cancel()
+
+	if err := database.DB.SetLatest(ctx, path, body.Version); err != nil {
+		sendStoreError(c, err)
+		return
+	}
+
+	slog.Info("rollback function",
+		slog.String("path", path),
+		slog.Int64("version", body.Version),
+	)
+	c.JSON(http.StatusOK, gin.H{
+		"path":   path,
+		"latest": body.Version,
+	})
+}
+
+func DeleteFunction(c *gin.Context) {
+	path, action, key := parseFunctionPath(c.Param("targetPath"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutRedis)
+	defer cancel()
+
+	var err error
+	switch {
+	case action == "":
+		err = database.DB.Delete(ctx, path)
+	case action == "versions" && key != "":
+		version, parseErr := strconv.ParseInt(key, 10, 64)
+		if parseErr != nil {
+			c.String(http.StatusBadRequest, "bad request: invalid version")
+			return
+		}
+		err = database.DB.DeleteVersion(ctx, path, version)
+	case action == "aliases" && key != "":
+		err = database.DB.DeleteAlias(ctx, path, key)
+	default:
+		c.String(http.StatusNotFound, "not found")
+		return
+	}
+	if err != nil {
+		sendStoreError(c, err)
+		return
+	}
+
+	slog.Info("delete function",
+		slog.String("path", path),
+		slog.String("action", action),
+		slog.String("key", key),
+	)
+	c.Status(http.StatusNoContent)
+}
+
+func listVersions(c *gin.Context, path string) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutRedis)
+	defer cancel()
+
+	fn, list, err := database.DB.Versions(ctx, path)
+	if err != nil {
+		sendStoreError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"path":     fn.Path,
+		"language": fn.Language,
+		"latest":   fn.Latest,
+		"data":     list,
+	})
+}
+
+func getVersion(c *gin.Context, path string, version int64) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutRedis)
+	defer cancel()
+
+	script, err := database.DB.Get(ctx, path, version)
+	if err != nil {
+		sendStoreError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"path":     script.Path,
+		"language": script.Language,
+		"version":  script.Timestamp,
+		"code":     script.Code,
+		"config":   script.Config,
+	})
+}
+
+func diffVersions(c *gin.Context, path string) {
+	from, err := strconv.ParseInt(c.Query("from"), 10, 64)
+	if err != nil {
+		c.String(http.StatusBadRequest,
+			"bad request: from is required",
+		)
+		return
+	}
+
+	// * to invalid, use latest
+	to, _ := strconv.ParseInt(c.Query("to"), 10, 64)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutRedis)
+	defer cancel()
+
+	fromScript, err := database.DB.Get(ctx, path, from)
+	if err != nil {
+		sendStoreError(c, err)
+		return
+	}
+	toScript, err := database.DB.Get(ctx, path, to)
+	if err != nil {
+		sendStoreError(c, err)
+		return
+	}
+
+	diff, err := utils.UnifiedDiff(
+		fmt.Sprintf("a/%s@%d", path, fromScript.Timestamp),
+		fmt.Sprintf("b/%s@%d", path, toScript.Timestamp),
+		fromScript.Code,
+		toScript.Code,
+	)
+	if err != nil {
+		c.String(http.StatusUnprocessableEntity,
+			fmt.Sprintf("unprocessable: %s", err.Error()),
+		)
+		return
+	}
+	c.String(http.StatusOK, diff)
+}
+
+func sendStoreError(c *gin.Context, err error) {
+	if errors.Is(err, database.ErrScriptNotFound) ||
+		errors.Is(err, database.ErrVersionNotFound) ||
+		errors.Is(err, database.ErrAliasNotFound) ||
+		errors.Is(err, database.ErrScheduleNotFound) ||
+		errors.Is(err, database.ErrKeyNotFound) {
+		c.String(http.StatusNotFound,
+			fmt.Sprintf("not found: %s", err.Error()),
+		)
+		return
+	}
+	if errors.Is(err, database.ErrVersionInUse) || errors.Is(err, database.ErrVersionAliased) {
+		c.String(http.StatusConflict,
+			fmt.Sprintf("conflict: %s", err.Error()),
+		)
+		return
+	}
+
+	slog.Error("failed to access store",
+		slog.String("error", err.Error()),
+	)
+	c.String(http.StatusInternalServerError, "Failed to access store")
+}
@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// * function input as JSON text, accepts any JSON value, a string stays a string
+type RunInput string
+
+func (in *RunInput) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		*in = ""
+		return nil
+	}
+	*in = RunInput(b)
+	return nil
+}
+
+// * legacy JSON-encoded string input, decoded only when the client asks with input_encoded
+func (in RunInput) decode() (RunInput, error) {
+	var raw string
+	if err := json.Unmarshal([]byte(in), &raw); err != nil {
+		return "", fmt.Errorf("input_encoded requires a string input")
+	}
+	if strings.TrimSpace(raw) != "" && !json.Valid([]byte(raw)) {
+		return "", fmt.Errorf("input must be valid JSON")
+	}
+	return RunInput(raw), nil
+}
+
+// * json body carries options, anything else is raw input
+func isJSONRequest(c *gin.Context) bool {
+	contentType := c.ContentType()
+	return contentType == "" ||
+		contentType == "application/json" ||
+		strings.HasSuffix(contentType, "+json")
+}
+
+// * raw body passed as is with its content type, binary as base64
+func getRawRunBody(c *gin.Context) (*RunBody, error) {
+	getCodeMaxSize()
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, codeMaxSize)
+	raw, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return nil, fmt.Errorf("bad request: %s", err.Error())
+	}
+
+	body := &RunBody{
+		Input:       RunInput(raw),
+		ContentType: c.GetHeader("Content-Type"),
+	}
+	if !strings.HasPrefix(c.ContentType(), "text/") &&
+		c.ContentType() != "application/x-www-form-urlencoded" || !utf8.Valid(raw) {
+		body.Input = RunInput(base64.StdEncoding.EncodeToString(raw))
+		body.IsBase64 = true
+	}
+	return body, nil
+}
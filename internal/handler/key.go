@@ -0,0 +1,118 @@
+package handler
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pardnchiu/go-faas/internal/auth"
+	"github.com/pardnchiu/go-faas/internal/database"
+	"github.com/pardnchiu/go-faas/internal/utils"
+)
+
+type KeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes" binding:"required"`
+	Prefix string   `json:"prefix"`
+}
+
+func CreateKey(c *gin.Context) {
+	var body KeyRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.String(http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if len(body.Scopes) == 0 {
+		c.String(http.StatusBadRequest, "bad request: scopes is required")
+		return
+	}
+	for _, scope := range body.Scopes {
+		if !slices.Contains(auth.Scopes, scope) {
+			c.String(http.StatusBadRequest, "bad request: unknown scope "+scope)
+			return
+		}
+	}
+	if strings.Contains(body.Prefix, "..") {
+		c.String(http.StatusBadRequest, "Invalid prefix")
+		return
+	}
+
+	id, err := utils.NewID()
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Failed to create key")
+		return
+	}
+	plain, hash, err := auth.NewKey()
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Failed to create key")
+		return
+	}
+
+	key := database.APIKey{
+		ID:        id,
+		Name:      body.Name,
+		Hash:      hash,
+		Scopes:    body.Scopes,
+		Prefix:    strings.TrimPrefix(body.Prefix, "/"),
+		CreatedAt: time.Now().Unix(),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutRedis)
+	defer cancel()
+
+	if err := database.DB.SaveKey(ctx, key); err != nil {
+		slog.Error("failed to save key",
+			slog.String("error", err.Error()),
+		)
+		c.String(http.StatusInternalServerError, "Failed to create key")
+		return
+	}
+
+	slog.Info("create api key",
+		slog.String("id", key.ID),
+		slog.String("scopes", strings.Join(key.Scopes, ",")),
+		slog.String("prefix", key.Prefix),
+	)
+
+	// * plain key is returned only here
+	key.Hash = ""
+	c.JSON(http.StatusCreated, gin.H{
+		"key":  plain,
+		"data": key,
+	})
+}
+
+func ListKeys(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutRedis)
+	defer cancel()
+
+	list, err := database.DB.ListKeys(ctx)
+	if err != nil {
+		sendStoreError(c, err)
+		return
+	}
+	for i := range list {
+		list[i].Hash = ""
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": list,
+	})
+}
+
+func DeleteKey(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutRedis)
+	defer cancel()
+
+	if err := database.DB.DeleteKey(ctx, c.Param("id")); err != nil {
+		sendStoreError(c, err)
+		return
+	}
+
+	slog.Info("revoke api key", slog.String("id", c.Param("id")))
+	c.Status(http.StatusNoContent)
+}
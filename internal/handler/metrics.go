@@ -0,0 +1,44 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pardnchiu/go-faas/internal/admission"
+	"github.com/pardnchiu/go-faas/internal/metrics"
+	"github.com/pardnchiu/go-faas/internal/sandbox"
+)
+
+// * inline code has no path
+const runNowName = "run-now"
+
+func GetMetrics(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"functions": metrics.Snapshot(),
+		"admission": admission.Snapshot(),
+	})
+}
+
+func recordUsage(body *RunBody, unit string, usage sandbox.Usage, duration time.Duration, failed bool) {
+	name := body.Path
+	if name == "" {
+		name = runNowName
+	}
+
+	slog.Info("invocation usage",
+		slog.String("function", name),
+		slog.Int64("version", body.Version),
+		slog.String("unit", unit),
+		slog.String("subject", body.Subject),
+		slog.Int64("duration_ms", duration.Milliseconds()),
+		slog.Int64("memory_peak_bytes", usage.MemoryPeak),
+		slog.Int64("cpu_usage_us", usage.CPUUsage),
+		slog.Int64("nr_throttled", usage.Throttled),
+		slog.Int64("throttled_us", usage.ThrottledTime),
+		slog.Int64("pids_peak", usage.PidsPeak),
+		slog.Bool("failed", failed),
+	)
+	metrics.Record(name, usage, duration, failed)
+}
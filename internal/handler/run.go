@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -12,22 +13,67 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/pardnchiu/go-faas/internal/admission"
+	"github.com/pardnchiu/go-faas/internal/auth"
 	"github.com/pardnchiu/go-faas/internal/database"
 	"github.com/pardnchiu/go-faas/internal/sandbox"
+	"github.com/pardnchiu/go-faas/internal/stream"
 	"github.com/pardnchiu/go-faas/internal/utils"
 )
 
 type RunBody struct {
-	Code     string `json:"code"`
-	Language string `json:"language"`
-	Input    string `json:"input"`
-	Stream   bool   `json:"stream"`
+	Code         string          `json:"code"`
+	Language     string          `json:"language"`
+	Input        RunInput        `json:"input"`
+	InputEncoded bool            `json:"input_encoded"`
+	Stream       StreamMode      `json:"stream"`
+	Envelope     bool            `json:"envelope"`
+	FailOnStderr *bool           `json:"fail_on_stderr"`
+	Limits       database.Limits `json:"limits"`
+	Config       database.Config `json:"-"`
+	Path         string          `json:"-"`
+	Version      int64           `json:"-"`
+	Subject      string          `json:"-"`
+	ContentType  string          `json:"-"`
+	IsBase64     bool            `json:"-"`
+}
+
+// * stream accepts true / "sse" for SSE and "ndjson" for chunked JSON lines
+type StreamMode string
+
+const (
+	streamSSE    StreamMode = "sse"
+	streamNDJSON StreamMode = "ndjson"
+)
+
+func (m *StreamMode) UnmarshalJSON(b []byte) error {
+	var on bool
+	if err := json.Unmarshal(b, &on); err == nil {
+		*m = ""
+		if on {
+			*m = streamSSE
+		}
+		return nil
+	}
+
+	var mode string
+	if err := json.Unmarshal(b, &mode); err != nil {
+		return fmt.Errorf("stream must be bool, \"sse\" or \"ndjson\"")
+	}
+	switch StreamMode(mode) {
+	case "", streamSSE, streamNDJSON:
+		*m = StreamMode(mode)
+		return nil
+	default:
+		return fmt.Errorf("stream must be bool, \"sse\" or \"ndjson\"")
+	}
 }
 
 var (
 	timeoutRedis    = 5 * time.Second
 	timeoutScript   time.Duration
-	timeoutRequest  time.Duration
+	timeoutMax      time.Duration
+	timeoutOnce     sync.Once
 	codeMaxSize     int64
 	codeMaxSizeOnce sync.Once
 	extMap          = map[string]string{
@@ -46,41 +92,48 @@ func Run(c *gin.Context) {
 	targetPath := c.Param("targetPath")
 	targetPath = strings.TrimPrefix(targetPath, "/")
 
-	queryVersion := c.Query("version")
-	var version int64
-	if queryVersion != "" {
-		// * version invalid, use latest
-		v, err := strconv.ParseInt(queryVersion, 10, 64)
-		if err == nil {
-			version = v
-		}
+	version, alias, err := getRunTarget(c)
+	if err != nil {
+		sendError(c, newRunError(errInvalidInput, err))
+		return
 	}
 
-	body, err := getRunBody(c)
+	var body *RunBody
+	if isJSONRequest(c) {
+		body, err = getRunBody(c)
+	} else {
+		body, err = getRawRunBody(c)
+	}
 	if err != nil {
-		c.String(http.StatusBadRequest, err.Error())
+		sendError(c, newRunError(errInvalidInput, err))
 		return
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), timeoutRedis)
 	defer cancel()
 
-	script, err := database.DB.Get(ctx, targetPath, version)
+	script, err := getScript(ctx, targetPath, version, alias)
 	if err != nil {
-		c.String(http.StatusNotFound,
-			fmt.Sprintf("bad request: %s", err.Error()),
-		)
+		sendError(c, newRunError(errNotFound, fmt.Errorf("not found: %w", err)))
 		return
 	}
 
+	// * report resolved version, alias may split traffic
+	c.Header("X-Function-Version", strconv.FormatInt(script.Timestamp, 10))
+
 	body.Code = script.Code
 	body.Language = script.Language
+	body.Config = script.Config
+	body.Path = targetPath
+	body.Version = script.Timestamp
 
 	slog.Info("run request",
 		"body_language", body.Language,
 		"body_code_size", len(body.Code),
 		"body_input_size", len(body.Input),
-		"script_path", targetPath)
+		"script_path", targetPath,
+		"script_version", script.Timestamp,
+		"script_alias", alias)
 	fmt.Printf("Code:\n")
 	fmt.Printf("%s\n\n", body.Code)
 
@@ -90,21 +143,17 @@ func Run(c *gin.Context) {
 func RunNow(c *gin.Context) {
 	body, err := getRunBody(c)
 	if err != nil {
-		c.String(http.StatusBadRequest, err.Error())
+		sendError(c, newRunError(errInvalidInput, err))
 		return
 	}
 
 	if _, ok := runtimeMap[body.Language]; !ok {
-		c.String(http.StatusBadRequest,
-			"bad request: unsupported language",
-		)
+		sendError(c, newRunError(errInvalidInput, fmt.Errorf("bad request: unsupported language")))
 		return
 	}
 
 	if strings.TrimSpace(body.Code) == "" {
-		c.String(http.StatusBadRequest,
-			"bad request: code is required",
-		)
+		sendError(c, newRunError(errInvalidInput, fmt.Errorf("bad request: code is required")))
 		return
 	}
 
@@ -115,7 +164,7 @@ func RunNow(c *gin.Context) {
 	)
 	fmt.Printf("Code:\n")
 	fmt.Printf("%s\n\n", body.Code)
-	if strings.TrimSpace(body.Input) != "" {
+	if strings.TrimSpace(string(body.Input)) != "" {
 		fmt.Printf("Input:\n")
 		fmt.Printf("%s\n", body.Input)
 	}
@@ -123,6 +172,31 @@ func RunNow(c *gin.Context) {
 	run(c, body)
 }
 
+func getRunTarget(c *gin.Context) (int64, string, error) {
+	queryVersion := c.Query("version")
+	alias := c.Query("alias")
+	if alias != "" && queryVersion != "" {
+		return 0, "", fmt.Errorf("bad request: version and alias are exclusive")
+	}
+
+	var version int64
+	if queryVersion != "" {
+		// * version invalid, use latest
+		v, err := strconv.ParseInt(queryVersion, 10, 64)
+		if err == nil {
+			version = v
+		}
+	}
+	return version, alias, nil
+}
+
+func getScript(ctx context.Context, path string, version int64, alias string) (*database.Script, error) {
+	if alias != "" {
+		return database.DB.GetAlias(ctx, path, alias)
+	}
+	return database.DB.Get(ctx, path, version)
+}
+
 func getRunBody(c *gin.Context) (*RunBody, error) {
 	getCodeMaxSize()
 
@@ -131,6 +205,16 @@ func getRunBody(c *gin.Context) (*RunBody, error) {
 	if err := c.ShouldBindJSON(&body); err != nil {
 		return nil, fmt.Errorf("bad request: %s", err.Error())
 	}
+	if err := body.Limits.Validate(); err != nil {
+		return nil, fmt.Errorf("bad request: limits: %s", err.Error())
+	}
+	if body.InputEncoded && body.Input != "" {
+		input, err := body.Input.decode()
+		if err != nil {
+			return nil, fmt.Errorf("bad request: %s", err.Error())
+		}
+		body.Input = input
+	}
 	return &body, nil
 }
 
@@ -141,43 +225,138 @@ func getCodeMaxSize() int64 {
 	return codeMaxSize
 }
 
+// * script timeout plus redis margin, function timeout clamped by TIMEOUT_SCRIPT_MAX
+func getTimeout(seconds int) time.Duration {
+	timeoutOnce.Do(func() {
+		timeoutScript = time.Duration(utils.GetWithDefaultInt("TIMEOUT_SCRIPT", 30)) * time.Second
+		timeoutMax = time.Duration(utils.GetWithDefaultInt("TIMEOUT_SCRIPT_MAX", int(timeoutScript/time.Second))) * time.Second
+	})
+
+	timeout := timeoutScript
+	if seconds > 0 {
+		timeout = min(time.Duration(seconds)*time.Second, timeoutMax)
+	}
+	return timeout + timeoutRedis
+}
+
+// * request limits override function config
+func (b *RunBody) limit() sandbox.Limit {
+	limits := b.Config.Limits.Merge(b.Limits)
+	return sandbox.Limit{
+		Memory: limits.Memory,
+		CPU:    limits.CPU,
+		Tasks:  limits.Tasks,
+		Swap:   limits.Swap,
+	}
+}
+
+// * wait for a sandbox slot, rejected run is overloaded
+func (b *RunBody) admit(ctx context.Context) (func(), error) {
+	release, err := admission.Acquire(ctx, admission.Request{
+		Language:      b.Language,
+		Function:      b.Path,
+		FunctionLimit: b.Config.Concurrency,
+	})
+	if err != nil {
+		return nil, newRunError(errOverloaded, fmt.Errorf("overloaded: %w", err))
+	}
+	return release, nil
+}
+
+// * request option overrides function config
+func (b *RunBody) failOnStderr() bool {
+	if b.FailOnStderr != nil {
+		return *b.FailOnStderr
+	}
+	return b.Config.FailOnStderr
+}
+
 func run(c *gin.Context, body *RunBody) {
+	body.Subject = auth.Subject(c)
+
+	// * admitted before any stream header, rejection is still a plain 429
+	release, err := body.admit(c.Request.Context())
+	if err != nil {
+		sendError(c, err)
+		return
+	}
 
-	if body.Stream {
-		flusher, ok := setStream(c)
+	if body.Stream != "" {
+		id, err := utils.NewID()
+		if err != nil {
+			release()
+			c.String(http.StatusInternalServerError,
+				fmt.Sprintf("failed to run: %s", err.Error()),
+			)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutRedis)
+		err = stream.Open(ctx, id, body.Path)
+		cancel()
+		if err != nil {
+			release()
+			slog.Error("failed to open stream",
+				slog.String("stream", id),
+				slog.String("error", err.Error()),
+			)
+			c.String(http.StatusInternalServerError, "Failed to open stream")
+			return
+		}
+
+		c.Header("X-Stream-ID", id)
+		flusher, ok := setStream(c, body.Stream)
 		if !ok {
+			release()
 			c.String(http.StatusInternalServerError,
 				"streaming unsupported",
 			)
 			return
 		}
 
-		ctx := c.Request.Context()
+		// * first event carries stream id, followers can resume with it
+		sendEvent(id, "start", strconv.Quote(id))
+		go func() {
+			defer release()
+			runStream(id, body)
+		}()
+		followStream(c, flusher, body.Stream, id, 0)
+		return
+	}
+	defer release()
 
-		res, err := runScriptWithSSE(body.Code, body.Language, body.Input, c.Writer, flusher, ctx)
+	if body.Envelope {
+		res, err := execScript(c.Request.Context(), body)
 		if err != nil {
-			sendDone(c.Writer, flusher, "error", strings.ReplaceAll(err.Error(), "\n", " "))
+			sendError(c, fmt.Errorf("failed to run: %w", err))
 			return
 		}
-		sendDone(c.Writer, flusher, "result", strings.ReplaceAll(res, "\n", " "))
+		// * failed run keeps its classified status, envelope still holds the details
+		status := http.StatusOK
+		if res.Err != nil {
+			status = errorStatus[errorCode(res.Err)]
+		}
+		c.JSON(status, newEnvelope(body, res))
 		return
 	}
 
-	output, err := runScript(body.Code, body.Language, body.Input)
+	output, err := runScript(c.Request.Context(), body)
 	if err != nil {
-		c.String(http.StatusInternalServerError,
-			fmt.Sprintf("failed to run: %s", err.Error()),
-		)
+		sendError(c, fmt.Errorf("failed to run: %w", err))
 		return
 	}
 
 	sendResult(c, output)
 }
 
-func setStream(c *gin.Context) (http.Flusher, bool) {
-	c.Writer.Header().Set("Content-Type", "text/event-stream")
+func setStream(c *gin.Context, mode StreamMode) (http.Flusher, bool) {
+	if mode == streamNDJSON {
+		c.Writer.Header().Set("Content-Type", "application/x-ndjson")
+	} else {
+		c.Writer.Header().Set("Content-Type", "text/event-stream")
+		c.Writer.Header().Set("Connection", "keep-alive")
+	}
 	c.Writer.Header().Set("Cache-Control", "no-cache")
-	c.Writer.Header().Set("Connection", "keep-alive")
 
 	flusher, ok := c.Writer.(http.Flusher)
 	if !ok {
@@ -189,106 +368,144 @@ func setStream(c *gin.Context) (http.Flusher, bool) {
 	return flusher, true
 }
 
-func runScript(code, lang, input string) (string, error) {
-	if timeoutScript == 0 {
-		timeoutScript = time.Duration(utils.GetWithDefaultInt("TIMEOUT_SCRIPT", 30)) * time.Second
-		timeoutRequest = timeoutScript + timeoutRedis
-	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), timeoutRequest)
-	defer cancel()
+type execResult struct {
+	Result   string
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	Usage    sandbox.Usage
+	Err      error
+	Timeout  bool
+	Startup  time.Duration
+	Duration time.Duration
+}
 
-	// * prepare stdin with JSON containing code and input
-	payload := map[string]string{
-		"code":  code,
-		"input": input,
+// * first stdin line is JSON with code and input, rest of stdin belongs to function
+// * content_type only for raw body input
+// * function env travels here, wrapper sets it inside the sandbox
+func (b *RunBody) payload() ([]byte, error) {
+	payload := map[string]any{
+		"code":  b.Code,
+		"input": b.Input,
+	}
+	if len(b.Config.Env) > 0 {
+		payload["env"] = b.Config.Env
+	}
+	if b.ContentType != "" {
+		payload["content_type"] = b.ContentType
+		payload["is_base64"] = b.IsBase64
 	}
 	payloadBody, err := json.Marshal(payload)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal payload: %w", err)
+		return nil, fmt.Errorf("failed to marshal payload: %w", err)
 	}
+	return append(payloadBody, '\n'), nil
+}
+
+// * run script to completion, err only when sandbox cannot start
+// * parent done (client left) terminates the sandbox like a timeout
+func execScript(parent context.Context, body *RunBody) (*execResult, error) {
+	timeoutRequest := getTimeout(body.Config.Timeout)
+
+	ctx, cancel := context.WithTimeout(parent, timeoutRequest)
+	defer cancel()
 
-	cmd, err := sandbox.SandboxCommand(ctx, lang)
+	payloadBody, err := body.payload()
 	if err != nil {
-		return "", fmt.Errorf("sandbox command: %w", err)
+		return nil, err
 	}
 
-	cmd.Stdin = strings.NewReader(string(payloadBody))
-
-	output, err := cmd.CombinedOutput()
+	cmd, scope, err := sandbox.SandboxCommand(ctx, body.Language, body.limit())
 	if err != nil {
-		// * timeout
-		if ctx.Err() == context.DeadlineExceeded {
-			return "", fmt.Errorf("execution timeout (max %v)", timeoutRequest)
-		}
-		return "", fmt.Errorf("%s: %s", err, string(output))
-	}
-
-	raw := strings.TrimSpace(string(output))
-	if raw != "" {
-		lines := strings.Split(raw, "\n")
-		for i := len(lines) - 1; i >= 0; i-- {
-			l := strings.TrimSpace(lines[i])
-			if l == "" {
-				continue
-			}
-			if json.Valid([]byte(l)) {
-				return l, nil
-			}
-		}
+		return nil, fmt.Errorf("sandbox command: %w", err)
 	}
 
-	result := cleanOutput(raw)
-	return result, nil
-}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin = bytes.NewReader(payloadBody)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
 
-func cleanOutput(output string) string {
-	rowList := strings.Split(output, "\n")
-	var newList []string
+	channel, err := sandbox.NewChannel(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("result channel: %w", err)
+	}
+	if err := channel.Start(cmd); err != nil {
+		return nil, fmt.Errorf("failed to start command: %w", err)
+	}
+	scope.Watch(cmd.Process.Pid)
+	defer trackRun(body, scope, cancel)()
+	err = cmd.Wait()
+	duration := time.Since(channel.Begin())
 
-	for _, row := range rowList {
-		row = strings.TrimSpace(row)
-		if row == "" {
-			continue
-		}
+	// * no result frame, function returned nothing
+	result, ok := channel.Result()
+	if !ok {
+		result = "null"
+	}
 
-		if strings.Contains(row, "Warning:") ||
-			strings.Contains(row, "MODULE_TYPELESS_PACKAGE_JSON") ||
-			strings.Contains(row, "Use `node --trace-warnings") ||
-			strings.Contains(row, "ExperimentalWarning") {
-			continue
+	res := &execResult{
+		Result:   result,
+		Stdout:   stdout.String(),
+		Stderr:   stderr.String(),
+		ExitCode: cmd.ProcessState.ExitCode(),
+		Usage:    scope.Usage(),
+		Startup:  channel.Startup(),
+		Duration: duration,
+	}
+	if err != nil {
+		res.Err = err
+		stopped := stopCause(ctx)
+		switch {
+		case parent.Err() != nil:
+			res.Err = fmt.Errorf("stopped to run script: client disconnected")
+		case stopped == errCancelled:
+			res.Err = fmt.Errorf("stopped to run script: canceled")
+		case stopped == errTimeout:
+			res.Err = fmt.Errorf("execution timeout (max %v)", timeoutRequest)
+			res.Timeout = true
 		}
+		res.Err = newRunError(classifyExit(cmd.ProcessState, stopped, res.Usage, body.limit(), duration), res.Err)
+	}
+	recordUsage(body, scope.Unit, res.Usage, duration, res.Err != nil)
+	return res, nil
+}
 
-		newList = append(newList, row)
+func runScript(ctx context.Context, body *RunBody) (string, error) {
+	res, err := execScript(ctx, body)
+	if err != nil {
+		return "", err
+	}
+	if res.Timeout {
+		return "", res.Err
+	}
+	if res.Err != nil {
+		return "", fmt.Errorf("%w: %s", res.Err, strings.TrimSpace(res.Stdout+res.Stderr))
 	}
 
-	return strings.Join(newList, "\n")
+	return res.Result, nil
 }
 
 func sendResult(c *gin.Context, output string) {
+	data, dataType := parseOutput(output)
+	c.JSON(http.StatusOK, gin.H{
+		"data": data,
+		"type": dataType,
+	})
+}
+
+// * detect output type, not valid JSON is text
+func parseOutput(output string) (any, string) {
 	var data any
-	if err := json.Unmarshal([]byte(output), &data); err == nil {
-		switch v := data.(type) {
-		case string:
-			c.JSON(http.StatusOK, gin.H{
-				"data": data,
-				"type": "string",
-			})
-		case float64, int, int64, json.Number:
-			c.JSON(http.StatusOK, gin.H{
-				"data": v,
-				"type": "number",
-			})
-		default:
-			c.JSON(http.StatusOK, gin.H{
-				"data": data,
-				"type": "json",
-			})
-		}
-	} else {
-		c.JSON(http.StatusOK, gin.H{
-			"data": output,
-			"type": "text",
-		})
+	if err := json.Unmarshal([]byte(output), &data); err != nil {
+		return output, "text"
+	}
+
+	switch v := data.(type) {
+	case string:
+		return v, "string"
+	case float64, int, int64, json.Number:
+		return v, "number"
+	default:
+		return v, "json"
 	}
 }
@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pardnchiu/go-faas/internal/sandbox"
+)
+
+// * in-flight invocation on this instance
+type RunInfo struct {
+	ID        string        `json:"id"`
+	Path      string        `json:"path"`
+	Version   int64         `json:"version,omitempty"`
+	Language  string        `json:"language"`
+	Unit      string        `json:"unit"`
+	Subject   string        `json:"subject,omitempty"`
+	StartedAt time.Time     `json:"started_at"`
+	Usage     sandbox.Usage `json:"usage"`
+}
+
+type runEntry struct {
+	info   RunInfo
+	scope  *sandbox.Scope
+	cancel context.CancelFunc
+}
+
+var (
+	runsMu sync.Mutex
+	runs   = map[string]*runEntry{}
+)
+
+// * register running sandbox, returned func removes it
+func trackRun(body *RunBody, scope *sandbox.Scope, cancel context.CancelFunc) func() {
+	name := body.Path
+	if name == "" {
+		name = runNowName
+	}
+
+	runsMu.Lock()
+	runs[scope.ID] = &runEntry{
+		info: RunInfo{
+			ID:        scope.ID,
+			Path:      name,
+			Version:   body.Version,
+			Language:  body.Language,
+			Unit:      scope.Unit,
+			Subject:   body.Subject,
+			StartedAt: time.Now(),
+		},
+		scope:  scope,
+		cancel: cancel,
+	}
+	runsMu.Unlock()
+
+	return func() {
+		runsMu.Lock()
+		defer runsMu.Unlock()
+		delete(runs, scope.ID)
+	}
+}
+
+func ListRuns(c *gin.Context) {
+	runsMu.Lock()
+	list := make([]RunInfo, 0, len(runs))
+	for _, entry := range runs {
+		info := entry.info
+		info.Usage = entry.scope.Snapshot()
+		list = append(list, info)
+	}
+	runsMu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].StartedAt.Before(list[j].StartedAt)
+	})
+	c.JSON(http.StatusOK, gin.H{
+		"runs": list,
+	})
+}
+
+// * cancel terminates the scope in background, run reports it as failed
+func CancelRun(c *gin.Context) {
+	runsMu.Lock()
+	entry, ok := runs[c.Param("id")]
+	runsMu.Unlock()
+	if !ok {
+		c.String(http.StatusNotFound, "not found: run not found")
+		return
+	}
+
+	entry.cancel()
+	c.JSON(http.StatusAccepted, gin.H{
+		"id":     entry.info.ID,
+		"status": "canceling",
+	})
+}
@@ -0,0 +1,160 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pardnchiu/go-faas/internal/auth"
+	"github.com/pardnchiu/go-faas/internal/database"
+	"github.com/pardnchiu/go-faas/internal/scheduler"
+	"github.com/pardnchiu/go-faas/internal/utils"
+)
+
+type ScheduleBody struct {
+	Cron    string   `json:"cron" binding:"required"`
+	Path    string   `json:"path" binding:"required"`
+	Version int64    `json:"version"`
+	Alias   string   `json:"alias"`
+	Input   RunInput `json:"input"`
+}
+
+func CreateSchedule(c *gin.Context) {
+	var body ScheduleBody
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.String(http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	body.Path = strings.TrimPrefix(body.Path, "/")
+
+	if !auth.AllowPath(c, body.Path) {
+		c.String(http.StatusForbidden, "forbidden: path not allowed")
+		return
+	}
+
+	if err := scheduler.Validate(body.Cron); err != nil {
+		c.String(http.StatusBadRequest, "bad request: invalid cron: "+err.Error())
+		return
+	}
+	if body.Version != 0 && body.Alias != "" {
+		c.String(http.StatusBadRequest,
+			"bad request: version and alias are exclusive",
+		)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutRedis)
+	defer cancel()
+
+	if _, err := getScript(ctx, body.Path, body.Version, body.Alias); err != nil {
+		sendStoreError(c, err)
+		return
+	}
+
+	id, err := utils.NewID()
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Failed to create schedule")
+		return
+	}
+
+	schedule := database.Schedule{
+		ID:        id,
+		Cron:      body.Cron,
+		Path:      body.Path,
+		Version:   body.Version,
+		Alias:     body.Alias,
+		Input:     string(body.Input),
+		Subject:   auth.Subject(c),
+		CreatedAt: time.Now().Unix(),
+	}
+	if err := database.DB.SaveSchedule(ctx, schedule); err != nil {
+		sendStoreError(c, err)
+		return
+	}
+
+	slog.Info("create schedule",
+		slog.String("id", id),
+		slog.String("cron", body.Cron),
+		slog.String("path", body.Path),
+	)
+	c.JSON(http.StatusCreated, schedule)
+}
+
+func ListSchedules(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutRedis)
+	defer cancel()
+
+	list, err := database.DB.ListSchedules(ctx)
+	if err != nil {
+		sendStoreError(c, err)
+		return
+	}
+
+	allowed := list[:0]
+	for _, schedule := range list {
+		if auth.AllowPath(c, schedule.Path) {
+			allowed = append(allowed, schedule)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": allowed,
+	})
+}
+
+func GetSchedule(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutRedis)
+	defer cancel()
+
+	schedule, err := database.DB.GetSchedule(ctx, c.Param("id"))
+	if err != nil {
+		sendStoreError(c, err)
+		return
+	}
+	if !auth.AllowPath(c, schedule.Path) {
+		c.String(http.StatusForbidden, "forbidden: path not allowed")
+		return
+	}
+
+	c.JSON(http.StatusOK, schedule)
+}
+
+func DeleteSchedule(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutRedis)
+	defer cancel()
+
+	schedule, err := database.DB.GetSchedule(ctx, c.Param("id"))
+	if err != nil {
+		sendStoreError(c, err)
+		return
+	}
+	if !auth.AllowPath(c, schedule.Path) {
+		c.String(http.StatusForbidden, "forbidden: path not allowed")
+		return
+	}
+
+	if err := database.DB.DeleteSchedule(ctx, c.Param("id")); err != nil {
+		sendStoreError(c, err)
+		return
+	}
+
+	slog.Info("delete schedule", slog.String("id", c.Param("id")))
+	c.Status(http.StatusNoContent)
+}
+
+// * scheduler executor, same path as /run without http
+func RunSchedule(schedule *database.Schedule) (string, int64, error) {
+	slog.Info("run schedule", "schedule_id", schedule.ID)
+	output, version, err := runStored(schedule.Path, schedule.Version, schedule.Alias, &RunBody{
+		Input:   RunInput(schedule.Input),
+		Subject: schedule.Subject,
+	})
+	if err != nil {
+		return "", version, fmt.Errorf("schedule %s: %w", schedule.ID, err)
+	}
+	return output, version, nil
+}
@@ -4,14 +4,18 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
+	"log/slog"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/gin-gonic/gin"
+	"github.com/pardnchiu/go-faas/internal/auth"
 	"github.com/pardnchiu/go-faas/internal/sandbox"
-	"github.com/pardnchiu/go-faas/internal/utils"
+	"github.com/pardnchiu/go-faas/internal/stream"
 )
 
 type SSE struct {
@@ -20,37 +24,110 @@ type SSE struct {
 	Type  string `json:"type,omitempty"`
 }
 
-func sendEvent(w http.ResponseWriter, flusher http.Flusher, event, output string) {
-	stream := SSE{Event: event}
-
-	var data any
-	if err := json.Unmarshal([]byte(output), &data); err == nil {
-		switch v := data.(type) {
-		case string:
-			stream.Data = v
-			stream.Type = "string"
-		case float64, int, int64, json.Number:
-			stream.Data = v
-			stream.Type = "number"
-		default:
-			stream.Data = v
-			stream.Type = "json"
-		}
+const streamPoll = 200 * time.Millisecond
+
+// * buffer event for followers, invocation does not depend on any connection
+func sendEvent(id, event, output string) {
+	payload := SSE{Event: event}
+	payload.Data, payload.Type = parseOutput(output)
+	b, _ := json.Marshal(payload)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutRedis)
+	defer cancel()
+
+	if _, err := stream.Append(ctx, id, event, string(b)); err != nil {
+		slog.Error("failed to buffer event",
+			slog.String("stream", id),
+			slog.String("error", err.Error()),
+		)
+	}
+}
+
+func runStream(id string, body *RunBody) {
+	res, err := runScriptWithSSE(id, body)
+	if err != nil {
+		// * same body as the non-stream error response
+		b, _ := json.Marshal(errorBody(err))
+		sendEvent(id, "error", string(b))
 	} else {
-		stream.Data = output
-		stream.Type = "text"
+		sendEvent(id, "result", strings.ReplaceAll(res, "\n", " "))
 	}
 
-	b, _ := json.Marshal(stream)
-	fmt.Fprintf(w, "data: %s\n\n", b)
-	flusher.Flush()
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutRedis)
+	defer cancel()
+
+	if err := stream.Finish(ctx, id); err != nil {
+		slog.Error("failed to finish stream",
+			slog.String("stream", id),
+			slog.String("error", err.Error()),
+		)
+	}
 }
 
-func sendDone(w http.ResponseWriter, flusher http.Flusher, event, msg string) {
-	sendEvent(w, flusher, event, msg)
-	flusher.Flush()
+// * write buffered events after given id until stream finished or client left
+func followStream(c *gin.Context, flusher http.Flusher, mode StreamMode, id string, after int64) {
+	ticker := time.NewTicker(streamPoll)
+	defer ticker.Stop()
+
+	var last string
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutRedis)
+		events, done, err := stream.Read(ctx, id, after)
+		cancel()
+		if err != nil {
+			slog.Error("failed to read stream",
+				slog.String("stream", id),
+				slog.String("error", err.Error()),
+			)
+			break
+		}
+
+		for _, e := range events {
+			if mode == streamNDJSON {
+				fmt.Fprintf(c.Writer, "%s\n", e.Data)
+			} else {
+				fmt.Fprintf(c.Writer, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Event, e.Data)
+			}
+			after = e.ID
+			last = e.Event
+		}
+		if len(events) > 0 {
+			flusher.Flush()
+		}
+		if done {
+			break
+		}
+
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+
+	// * terminal record, response ends normally without hijack
+	if mode == streamNDJSON {
+		// * resumed after final event, look it up
+		if last == "" && after > 0 {
+			ctx, cancel := context.WithTimeout(context.Background(), timeoutRedis)
+			if events, _, err := stream.Read(ctx, id, after-1); err == nil && len(events) > 0 {
+				last = events[0].Event
+			}
+			cancel()
+		}
+
+		status := "succeeded"
+		if last != "result" {
+			status = "failed"
+		}
+		b, _ := json.Marshal(gin.H{"event": "done", "status": status})
+		fmt.Fprintf(c.Writer, "%s\n", b)
+		flusher.Flush()
+		return
+	}
 
-	hj, ok := w.(http.Hijacker)
+	hj, ok := c.Writer.(http.Hijacker)
 	if !ok {
 		return
 	}
@@ -61,16 +138,69 @@ func sendDone(w http.ResponseWriter, flusher http.Flusher, event, msg string) {
 	_ = conn.Close()
 }
 
-func runScriptWithSSE(code, lang, input string, w http.ResponseWriter, flusher http.Flusher, clientCtx context.Context) (string, error) {
-	if timeoutScript == 0 {
-		timeoutScript = time.Duration(utils.GetWithDefaultInt("TIMEOUT_SCRIPT", 30)) * time.Second
-		timeoutRequest = timeoutScript + timeoutRedis
+// * reconnect to a stream, replay events after Last-Event-ID
+func GetStream(c *gin.Context) {
+	id := c.Param("id")
+	lastID := c.GetHeader("Last-Event-ID")
+	if lastID == "" {
+		lastID = c.Query("last_event_id")
+	}
+	after := stream.ParseID(lastID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutRedis)
+	defer cancel()
+
+	path, err := stream.Path(ctx, id)
+	if err == nil {
+		_, _, err = stream.Read(ctx, id, after)
 	}
+	if err != nil {
+		if errors.Is(err, stream.ErrStreamNotFound) {
+			c.String(http.StatusNotFound, "not found: stream not found")
+			return
+		}
+		slog.Error("failed to read stream",
+			slog.String("stream", id),
+			slog.String("error", err.Error()),
+		)
+		c.String(http.StatusInternalServerError, "Failed to read stream")
+		return
+	}
+
+	// * resumed output needs the same permission as starting the run
+	switch {
+	case path == "" && !auth.HasScope(c, auth.ScopeRunNow):
+		c.String(http.StatusForbidden, "forbidden: requires scope run-now")
+		return
+	case path != "" && (!auth.HasScope(c, auth.ScopeRun) || !auth.AllowPath(c, path)):
+		c.String(http.StatusForbidden, "forbidden: path not allowed")
+		return
+	}
+
+	// * resume as ndjson when client asks for it
+	mode := streamSSE
+	if strings.Contains(c.GetHeader("Accept"), "application/x-ndjson") {
+		mode = streamNDJSON
+	}
+
+	c.Header("X-Stream-ID", id)
+	flusher, ok := setStream(c, mode)
+	if !ok {
+		c.String(http.StatusInternalServerError,
+			"streaming unsupported",
+		)
+		return
+	}
+	followStream(c, flusher, mode, id, after)
+}
+
+func runScriptWithSSE(id string, body *RunBody) (string, error) {
+	timeoutRequest := getTimeout(body.Config.Timeout)
 
 	ctx, execCancel := context.WithTimeout(context.Background(), timeoutRequest)
 	defer execCancel()
 
-	cmd, err := sandbox.SandboxCommand(ctx, lang)
+	cmd, scope, err := sandbox.SandboxCommand(ctx, body.Language, body.limit())
 	if err != nil {
 		return "", fmt.Errorf("sandbox command: %w", err)
 	}
@@ -90,24 +220,35 @@ func runScriptWithSSE(code, lang, input string, w http.ResponseWriter, flusher h
 		return "", fmt.Errorf("stderr pipe: %w", err)
 	}
 
-	if err := cmd.Start(); err != nil {
+	channel, err := sandbox.NewChannel(cmd)
+	if err != nil {
+		return "", fmt.Errorf("result channel: %w", err)
+	}
+	if err := channel.Start(cmd); err != nil {
 		return "", fmt.Errorf("failed to start command: %w", err)
 	}
+	scope.Watch(cmd.Process.Pid)
+	defer trackRun(body, scope, execCancel)()
 
-	// * prepare stdin with JSON containing code and input
 	go func() {
-		payload := map[string]string{
-			"code":  code,
-			"input": input,
-		}
-		payloadBody, _ := json.Marshal(payload)
-		io.WriteString(stdin, string(payloadBody))
+		payloadBody, _ := body.payload()
+		stdin.Write(payloadBody)
 		stdin.Close()
 	}()
 
 	outScanner := bufio.NewScanner(stdout)
 	errScanner := bufio.NewScanner(stderr)
-	var lastLine string
+
+	failOnStderr := body.failOnStderr()
+
+	// * stdout and stderr readers share the writer
+	var mu sync.Mutex
+	var lastErr string
+	send := func(event, line string) {
+		mu.Lock()
+		defer mu.Unlock()
+		sendEvent(id, event, line)
+	}
 
 	doneChan := make(chan struct{}, 2)
 	errChan := make(chan string, 1)
@@ -117,27 +258,36 @@ func runScriptWithSSE(code, lang, input string, w http.ResponseWriter, flusher h
 			doneChan <- struct{}{}
 		}()
 
-		// * only send previous line as log
-		var prev string
+		// * stdout is logs only, result comes from channel
 		for outScanner.Scan() {
-			if prev != "" {
-				sendEvent(w, flusher, "log", prev)
-				flusher.Flush()
+			if line := outScanner.Text(); line != "" {
+				send("log", line)
 			}
-			prev = outScanner.Text()
 		}
-		lastLine = strings.TrimSpace(prev)
 	}()
 
 	go func() {
 		defer func() { doneChan <- struct{}{} }()
 
 		for errScanner.Scan() {
-			select {
-			// * send any error when found, and stop script
-			case errChan <- errScanner.Text():
-			default:
+			line := errScanner.Text()
+			if strings.TrimSpace(line) == "" {
+				continue
 			}
+			if failOnStderr {
+				select {
+				// * fail-fast, send first error and stop script
+				case errChan <- line:
+				default:
+				}
+				continue
+			}
+
+			// * forward as event, failure decided by exit code
+			mu.Lock()
+			lastErr = line
+			mu.Unlock()
+			send("stderr", line)
 		}
 	}()
 
@@ -148,13 +298,10 @@ func runScriptWithSSE(code, lang, input string, w http.ResponseWriter, flusher h
 
 	var resultErr error
 	select {
-	case <-clientCtx.Done():
-		// * request canceled
-		_ = cmd.Process.Kill()
-		resultErr = fmt.Errorf("stopped to run script: client disconnected")
 	case <-ctx.Done():
-		// * execution timeout
-		_ = cmd.Process.Kill()
+		// * timeout or DELETE /runs/:id, cmd.Cancel already terminating the scope
+		_ = scope.Terminate(cmd.Process)
+		<-procDone
 		if ctx.Err() == context.DeadlineExceeded {
 			resultErr = fmt.Errorf("stopped to run script: timeout (max %v)", timeoutRequest)
 		} else {
@@ -162,10 +309,11 @@ func runScriptWithSSE(code, lang, input string, w http.ResponseWriter, flusher h
 		}
 	case errMsg := <-errChan:
 		// * received error output
-		_ = cmd.Process.Kill()
-		resultErr = fmt.Errorf("stopped to run script: %s", strings.TrimSpace(errMsg))
+		_ = scope.Terminate(cmd.Process)
+		<-procDone
+		resultErr = newRunError(errUserException, fmt.Errorf("stopped to run script: %s", strings.TrimSpace(errMsg)))
 	case err := <-procDone:
-		// * no error output, but exit code != 0
+		// * exit code != 0
 		if err != nil {
 			resultErr = fmt.Errorf("stopped to run script: %w", err)
 		}
@@ -174,8 +322,28 @@ func runScriptWithSSE(code, lang, input string, w http.ResponseWriter, flusher h
 	<-doneChan
 	<-doneChan
 
+	usage := scope.Usage()
+	duration := time.Since(channel.Begin())
+	recordUsage(body, scope.Unit, usage, duration, resultErr != nil)
+
+	var runErr *RunError
+	if resultErr != nil && !errors.As(resultErr, &runErr) {
+		resultErr = newRunError(classifyExit(cmd.ProcessState, stopCause(ctx), usage, body.limit(), duration), resultErr)
+	}
+
+	// * last stderr line usually holds the exception
+	if resultErr != nil && lastErr != "" {
+		resultErr = fmt.Errorf("%w: %s", resultErr, strings.TrimSpace(lastErr))
+	}
+
 	if resultErr != nil {
 		return "", resultErr
 	}
-	return strings.TrimSpace(lastLine), nil
+
+	// * no result frame, function returned nothing
+	result, ok := channel.Result()
+	if !ok {
+		result = "null"
+	}
+	return result, nil
 }
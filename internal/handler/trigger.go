@@ -0,0 +1,218 @@
+package handler
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pardnchiu/go-faas/internal/auth"
+)
+
+// * event passed as input to function triggered over http
+type HTTPEvent struct {
+	Method   string            `json:"method"`
+	Path     string            `json:"path"`
+	Headers  map[string]string `json:"headers"`
+	Query    map[string]string `json:"query"`
+	RawQuery string            `json:"raw_query"`
+	Body     any               `json:"body"`
+	IsBase64 bool              `json:"is_base64"`
+}
+
+// * function return value written back verbatim
+type HTTPResponse struct {
+	Status   int               `json:"status"`
+	Headers  map[string]string `json:"headers"`
+	Body     any               `json:"body"`
+	IsBase64 bool              `json:"is_base64"`
+}
+
+// * set by server, function can not overwrite
+var skipHeaders = map[string]bool{
+	"Content-Length":    true,
+	"Transfer-Encoding": true,
+	"Connection":        true,
+}
+
+func Trigger(c *gin.Context) {
+	targetPath := strings.TrimPrefix(c.Param("targetPath"), "/")
+
+	// * query belongs to function, select version by header
+	var version int64
+	if v, err := strconv.ParseInt(c.GetHeader("X-Function-Version"), 10, 64); err == nil {
+		version = v
+	}
+	alias := c.GetHeader("X-Function-Alias")
+	if alias != "" && version != 0 {
+		sendError(c, newRunError(errInvalidInput, fmt.Errorf("bad request: version and alias are exclusive")))
+		return
+	}
+
+	event, err := getHTTPEvent(c)
+	if err != nil {
+		sendError(c, newRunError(errInvalidInput, err))
+		return
+	}
+	input, err := json.Marshal(event)
+	if err != nil {
+		c.String(http.StatusInternalServerError,
+			fmt.Sprintf("failed to marshal event: %s", err.Error()),
+		)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutRedis)
+	defer cancel()
+
+	script, err := getScript(ctx, targetPath, version, alias)
+	if err != nil {
+		sendError(c, newRunError(errNotFound, fmt.Errorf("not found: %w", err)))
+		return
+	}
+
+	slog.Info("trigger request",
+		slog.String("method", event.Method),
+		slog.String("script_path", targetPath),
+		slog.Int64("script_version", script.Timestamp),
+		slog.String("script_alias", alias),
+	)
+
+	c.Header("X-Function-Version", strconv.FormatInt(script.Timestamp, 10))
+
+	body := &RunBody{
+		Code:     script.Code,
+		Language: script.Language,
+		Input:    RunInput(input),
+		Config:   script.Config,
+		Path:     targetPath,
+		Version:  script.Timestamp,
+		Subject:  auth.Subject(c),
+	}
+	release, err := body.admit(c.Request.Context())
+	if err != nil {
+		sendError(c, err)
+		return
+	}
+	defer release()
+
+	output, err := runScript(c.Request.Context(), body)
+	if err != nil {
+		sendError(c, fmt.Errorf("failed to run: %w", err))
+		return
+	}
+
+	sendHTTPResponse(c, output)
+}
+
+func getHTTPEvent(c *gin.Context) (*HTTPEvent, error) {
+	event := &HTTPEvent{
+		Method:   c.Request.Method,
+		Path:     c.Request.URL.Path,
+		Headers:  map[string]string{},
+		Query:    map[string]string{},
+		RawQuery: c.Request.URL.RawQuery,
+	}
+	for key, values := range c.Request.Header {
+		// * function of another uploader must not see the caller credentials
+		if auth.IsCredentialHeader(key) {
+			continue
+		}
+		event.Headers[strings.ToLower(key)] = strings.Join(values, ", ")
+	}
+	for key, values := range c.Request.URL.Query() {
+		event.Query[key] = values[0]
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, getCodeMaxSize())
+	raw, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return nil, fmt.Errorf("bad request: %s", err.Error())
+	}
+	if len(raw) == 0 {
+		return event, nil
+	}
+
+	// * json parsed, text as string, anything else base64
+	mediaType, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		if err := json.Unmarshal(raw, &event.Body); err != nil {
+			return nil, fmt.Errorf("bad request: invalid json body")
+		}
+	case utf8.Valid(raw):
+		event.Body = string(raw)
+	default:
+		event.Body = base64.StdEncoding.EncodeToString(raw)
+		event.IsBase64 = true
+	}
+	return event, nil
+}
+
+// * object with status or body is http response, anything else is json body
+func sendHTTPResponse(c *gin.Context, output string) {
+	var fields map[string]json.RawMessage
+	var res HTTPResponse
+	if json.Unmarshal([]byte(output), &fields) != nil ||
+		(fields["status"] == nil && fields["body"] == nil) ||
+		json.Unmarshal([]byte(output), &res) != nil {
+		c.Data(http.StatusOK, "application/json; charset=utf-8", []byte(output))
+		return
+	}
+
+	status := res.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	// * informational status can not be a final response
+	if status < 200 || status > 599 {
+		c.String(http.StatusInternalServerError,
+			fmt.Sprintf("failed to run: invalid status %d", status),
+		)
+		return
+	}
+
+	var body []byte
+	contentType := "text/plain; charset=utf-8"
+	switch v := res.Body.(type) {
+	case nil:
+	case string:
+		body = []byte(v)
+		if res.IsBase64 {
+			decoded, err := base64.StdEncoding.DecodeString(v)
+			if err != nil {
+				c.String(http.StatusInternalServerError,
+					"failed to run: invalid base64 body",
+				)
+				return
+			}
+			body = decoded
+			contentType = "application/octet-stream"
+		}
+	default:
+		body, _ = json.Marshal(v)
+		contentType = "application/json; charset=utf-8"
+	}
+
+	for key, value := range res.Headers {
+		key = http.CanonicalHeaderKey(key)
+		if skipHeaders[key] {
+			continue
+		}
+		if key == "Content-Type" {
+			contentType = value
+			continue
+		}
+		c.Header(key, value)
+	}
+
+	c.Data(status, contentType, body)
+}
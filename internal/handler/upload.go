@@ -8,13 +8,15 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/pardnchiu/go-faas/internal/auth"
 	"github.com/pardnchiu/go-faas/internal/database"
 )
 
 type UploadRequest struct {
-	Path     string `json:"path" binding:"required"`
-	Code     string `json:"code" binding:"required"`
-	Language string `json:"language" binding:"required"`
+	Path     string          `json:"path" binding:"required"`
+	Code     string          `json:"code" binding:"required"`
+	Language string          `json:"language" binding:"required"`
+	Config   database.Config `json:"config"`
 }
 
 func Upload(c *gin.Context) {
@@ -30,11 +32,28 @@ func Upload(c *gin.Context) {
 		return
 	}
 
+	if hasReservedSegment(req.Path) {
+		c.String(http.StatusBadRequest,
+			"Invalid path: segments versions, aliases, diff and rollback are reserved",
+		)
+		return
+	}
+
+	if !auth.AllowPath(c, req.Path) {
+		c.String(http.StatusForbidden, "forbidden: path not allowed")
+		return
+	}
+
 	if req.Language != "python" && req.Language != "javascript" && req.Language != "typescript" {
 		c.String(http.StatusBadRequest, "Invalid path")
 		return
 	}
 
+	if err := req.Config.Validate(); err != nil {
+		c.String(http.StatusBadRequest, "Invalid config: "+err.Error())
+		return
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -42,6 +61,8 @@ func Upload(c *gin.Context) {
 		Path:     req.Path,
 		Code:     req.Code,
 		Language: req.Language,
+		Config:   req.Config,
+		Author:   auth.Subject(c),
 	})
 
 	if err != nil {
@@ -52,6 +73,12 @@ func Upload(c *gin.Context) {
 		return
 	}
 
+	slog.Info("upload function",
+		slog.String("path", req.Path),
+		slog.Int64("version", version),
+		slog.String("subject", auth.Subject(c)),
+	)
+
 	c.JSON(http.StatusOK, gin.H{
 		"path":     req.Path,
 		"language": req.Language,
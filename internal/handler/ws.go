@@ -0,0 +1,282 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/pardnchiu/go-faas/internal/auth"
+	"github.com/pardnchiu/go-faas/internal/database"
+	"github.com/pardnchiu/go-faas/internal/sandbox"
+)
+
+// * first client message, stored function by path or code like run-now
+type WSStart struct {
+	Path     string          `json:"path"`
+	Version  int64           `json:"version"`
+	Alias    string          `json:"alias"`
+	Code     string          `json:"code"`
+	Language string          `json:"language"`
+	Input    RunInput        `json:"input"`
+	Limits   database.Limits `json:"limits"`
+}
+
+// * client message after start, "stdin" with data or "eof"
+type WSMessage struct {
+	Type string `json:"type"`
+	Data string `json:"data"`
+}
+
+// * server message, "stdout" / "stderr" chunks then one "result" or "error"
+type WSFrame struct {
+	Type     string    `json:"type"`
+	Data     any       `json:"data,omitempty"`
+	DataType string    `json:"data_type,omitempty"`
+	Code     ErrorCode `json:"error_code,omitempty"`
+	ExitCode *int      `json:"exit_code,omitempty"`
+}
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+}
+
+func RunWS(c *gin.Context) {
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// * upgrader already replied
+		return
+	}
+	defer conn.Close()
+
+	conn.SetReadLimit(getCodeMaxSize())
+
+	var start WSStart
+	if err := conn.ReadJSON(&start); err != nil {
+		closeWS(conn, errorFrame(newRunError(errInvalidInput, fmt.Errorf("bad request: %w", err)), nil))
+		return
+	}
+
+	// * ws route admits run or run-now, the start message decides which
+	switch {
+	case start.Path == "" && !auth.HasScope(c, auth.ScopeRunNow):
+		closeWS(conn, WSFrame{Type: "error", Data: "forbidden: requires scope run-now"})
+		return
+	case start.Path != "" && (!auth.HasScope(c, auth.ScopeRun) || !auth.AllowPath(c, start.Path)):
+		closeWS(conn, WSFrame{Type: "error", Data: "forbidden: path not allowed"})
+		return
+	}
+
+	body, err := getWSRunBody(start)
+	if err != nil {
+		closeWS(conn, errorFrame(err, nil))
+		return
+	}
+	body.Subject = auth.Subject(c)
+
+	slog.Info("ws run request",
+		slog.String("language", body.Language),
+		slog.String("path", start.Path),
+		slog.Int64("version", body.Version),
+		slog.Int("code_size", len(body.Code)),
+	)
+
+	release, err := body.admit(context.Background())
+	if err != nil {
+		closeWS(conn, errorFrame(err, nil))
+		return
+	}
+	defer release()
+
+	closeWS(conn, runWS(conn, body))
+}
+
+// * error frame carries the same code as the http error body
+func errorFrame(err error, exitCode *int) WSFrame {
+	return WSFrame{Type: "error", Data: err.Error(), Code: errorCode(err), ExitCode: exitCode}
+}
+
+func getWSRunBody(start WSStart) (*RunBody, error) {
+	if err := start.Limits.Validate(); err != nil {
+		return nil, newRunError(errInvalidInput, fmt.Errorf("bad request: limits: %s", err.Error()))
+	}
+
+	if start.Path == "" {
+		if _, ok := runtimeMap[start.Language]; !ok {
+			return nil, newRunError(errInvalidInput, fmt.Errorf("bad request: unsupported language"))
+		}
+		if strings.TrimSpace(start.Code) == "" {
+			return nil, newRunError(errInvalidInput, fmt.Errorf("bad request: code is required"))
+		}
+		return &RunBody{
+			Code:     start.Code,
+			Language: start.Language,
+			Input:    start.Input,
+			Limits:   start.Limits,
+		}, nil
+	}
+
+	if start.Version != 0 && start.Alias != "" {
+		return nil, newRunError(errInvalidInput, fmt.Errorf("bad request: version and alias are exclusive"))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutRedis)
+	defer cancel()
+
+	script, err := getScript(ctx, strings.TrimPrefix(start.Path, "/"), start.Version, start.Alias)
+	if err != nil {
+		return nil, newRunError(errNotFound, fmt.Errorf("not found: %s", err.Error()))
+	}
+	return &RunBody{
+		Code:     script.Code,
+		Language: script.Language,
+		Input:    start.Input,
+		Limits:   start.Limits,
+		Config:   script.Config,
+		Path:     strings.TrimPrefix(start.Path, "/"),
+		Version:  script.Timestamp,
+	}, nil
+}
+
+// * run with stdin kept open, client messages feed stdin until "eof"
+func runWS(conn *websocket.Conn, body *RunBody) WSFrame {
+	timeoutRequest := getTimeout(body.Config.Timeout)
+
+	timeoutCtx, timeoutCancel := context.WithTimeout(context.Background(), timeoutRequest)
+	defer timeoutCancel()
+
+	// * canceled when client leaves or run is deleted
+	ctx, cancel := context.WithCancel(timeoutCtx)
+	defer cancel()
+
+	cmd, scope, err := sandbox.SandboxCommand(ctx, body.Language, body.limit())
+	if err != nil {
+		return errorFrame(fmt.Errorf("sandbox command: %w", err), nil)
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return errorFrame(fmt.Errorf("stdin pipe: %w", err), nil)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return errorFrame(fmt.Errorf("stdout pipe: %w", err), nil)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return errorFrame(fmt.Errorf("stderr pipe: %w", err), nil)
+	}
+
+	channel, err := sandbox.NewChannel(cmd)
+	if err != nil {
+		return errorFrame(fmt.Errorf("result channel: %w", err), nil)
+	}
+	if err := channel.Start(cmd); err != nil {
+		return errorFrame(fmt.Errorf("failed to start command: %w", err), nil)
+	}
+	scope.Watch(cmd.Process.Pid)
+	defer trackRun(body, scope, cancel)()
+
+	payloadBody, _ := body.payload()
+	stdin.Write(payloadBody)
+
+	var wmu sync.Mutex
+	send := func(frame WSFrame) {
+		wmu.Lock()
+		defer wmu.Unlock()
+		conn.WriteJSON(frame)
+	}
+
+	// * client messages, read error means client left
+	go func() {
+		for {
+			var msg WSMessage
+			if err := conn.ReadJSON(&msg); err != nil {
+				cancel()
+				return
+			}
+			switch msg.Type {
+			case "stdin":
+				io.WriteString(stdin, msg.Data)
+			case "eof":
+				stdin.Close()
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	pump := func(r io.Reader, frameType string) {
+		defer wg.Done()
+
+		buf := make([]byte, 4096)
+		var pending []byte
+		for {
+			n, err := r.Read(buf)
+			if n > 0 {
+				pending = append(pending, buf[:n]...)
+				// * hold incomplete utf-8 tail for next chunk
+				cut := len(pending)
+				for cut > 0 && len(pending)-cut < utf8.UTFMax && !utf8.Valid(pending[:cut]) {
+					cut--
+				}
+				if cut == 0 {
+					cut = len(pending)
+				}
+				send(WSFrame{Type: frameType, Data: string(pending[:cut])})
+				pending = append([]byte(nil), pending[cut:]...)
+			}
+			if err != nil {
+				if len(pending) > 0 {
+					send(WSFrame{Type: frameType, Data: string(pending)})
+				}
+				return
+			}
+		}
+	}
+	wg.Add(2)
+	go pump(stdout, "stdout")
+	go pump(stderr, "stderr")
+	wg.Wait()
+
+	err = cmd.Wait()
+	exitCode := cmd.ProcessState.ExitCode()
+	usage := scope.Usage()
+	duration := time.Since(channel.Begin())
+	recordUsage(body, scope.Unit, usage, duration, err != nil)
+
+	// * same classification as http and sse runs
+	if stopped := stopCause(ctx); err != nil || stopped != "" {
+		switch stopped {
+		case errTimeout:
+			err = fmt.Errorf("execution timeout (max %v)", timeoutRequest)
+		case errCancelled:
+			err = fmt.Errorf("stopped to run script: canceled")
+		default:
+			err = fmt.Errorf("stopped to run script: %w", err)
+		}
+		return errorFrame(newRunError(classifyExit(cmd.ProcessState, stopped, usage, body.limit(), duration), err), &exitCode)
+	}
+
+	// * no result frame, function returned nothing
+	result, ok := channel.Result()
+	if !ok {
+		result = "null"
+	}
+	frame := WSFrame{Type: "result", ExitCode: &exitCode}
+	frame.Data, frame.DataType = parseOutput(result)
+	return frame
+}
+
+func closeWS(conn *websocket.Conn, frame WSFrame) {
+	conn.WriteJSON(frame)
+	conn.WriteMessage(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, frame.Type),
+	)
+}
@@ -0,0 +1,57 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pardnchiu/go-faas/internal/sandbox"
+)
+
+// * aggregated per function since process start
+type Function struct {
+	Invocations   int64 `json:"invocations"`
+	Failures      int64 `json:"failures"`
+	Duration      int64 `json:"duration_ms"`
+	CPUUsage      int64 `json:"cpu_usage_us"`
+	Throttled     int64 `json:"nr_throttled"`
+	ThrottledTime int64 `json:"throttled_us"`
+	MemoryPeak    int64 `json:"memory_peak_bytes"`
+	PidsPeak      int64 `json:"pids_peak"`
+}
+
+var (
+	mu        sync.Mutex
+	functions = map[string]*Function{}
+)
+
+func Record(name string, usage sandbox.Usage, duration time.Duration, failed bool) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	f := functions[name]
+	if f == nil {
+		f = &Function{}
+		functions[name] = f
+	}
+	f.Invocations++
+	if failed {
+		f.Failures++
+	}
+	f.Duration += duration.Milliseconds()
+	f.CPUUsage += usage.CPUUsage
+	f.Throttled += usage.Throttled
+	f.ThrottledTime += usage.ThrottledTime
+	f.MemoryPeak = max(f.MemoryPeak, usage.MemoryPeak)
+	f.PidsPeak = max(f.PidsPeak, usage.PidsPeak)
+}
+
+func Snapshot() map[string]Function {
+	mu.Lock()
+	defer mu.Unlock()
+
+	out := make(map[string]Function, len(functions))
+	for name, f := range functions {
+		out[name] = *f
+	}
+	return out
+}
@@ -0,0 +1,361 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pardnchiu/go-faas/internal/utils"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	queueKey = "queue:jobs"
+	// * per worker list holding the job it runs, requeued after a crash
+	processingPrefix = "queue:processing:"
+	// * instance heartbeat, its processing lists are reclaimed once it expires
+	leasePrefix = "queue:lease:"
+	leaseTTL    = 30 * time.Second
+
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+var (
+	ErrDisabled    = errors.New("async queue requires redis store")
+	ErrJobNotFound = errors.New("job not found")
+
+	rdb        *redis.Client
+	jobTTL     time.Duration
+	maxRequeue int
+	execute    Executor
+	cancel     context.CancelFunc
+	wg         sync.WaitGroup
+	instanceID string
+)
+
+type Job struct {
+	ID         string          `json:"id"`
+	Path       string          `json:"path"`
+	Version    int64           `json:"version"`
+	Alias      string          `json:"alias,omitempty"`
+	Subject    string          `json:"subject,omitempty"`
+	Options    json.RawMessage `json:"options,omitempty"`
+	Input      string          `json:"-"`
+	Status     string          `json:"status"`
+	Output     string          `json:"-"`
+	Error      string          `json:"error,omitempty"`
+	Requeues   int             `json:"requeues,omitempty"`
+	CreatedAt  int64           `json:"created_at"`
+	StartedAt  int64           `json:"started_at,omitempty"`
+	FinishedAt int64           `json:"finished_at,omitempty"`
+}
+
+// * run stored function of job, return output and resolved version
+type Executor func(job *Job) (string, int64, error)
+
+// * temporary failure, job goes back to queue after Delay
+type RequeueError struct {
+	Delay time.Duration
+	Err   error
+}
+
+func (e *RequeueError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RequeueError) Unwrap() error {
+	return e.Err
+}
+
+func Start(client *redis.Client, exec Executor) {
+	rdb = client
+	execute = exec
+	jobTTL = time.Duration(utils.GetWithDefaultInt("JOB_TTL_SECONDS", 3600)) * time.Second
+	workers := utils.GetWithDefaultInt("JOB_WORKERS", 2)
+	maxRequeue = utils.GetWithDefaultInt("JOB_MAX_REQUEUES", 5)
+
+	// * unique per process, instances on one host never share a processing list
+	id, err := utils.NewID()
+	if err != nil {
+		slog.Error("failed to create instance id", slog.String("error", err.Error()))
+		return
+	}
+	instanceID = id
+	renewLease()
+	reclaim()
+
+	var ctx context.Context
+	ctx, cancel = context.WithCancel(context.Background())
+	wg.Add(1)
+	go heartbeat(ctx)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go worker(ctx, processingPrefix+instanceID+":"+strconv.Itoa(i))
+	}
+	slog.Info("job queue started", "workers", workers, "instance", instanceID)
+}
+
+func renewLease() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := rdb.Set(ctx, leasePrefix+instanceID, time.Now().Unix(), leaseTTL).Err(); err != nil {
+		slog.Error("failed to renew queue lease", slog.String("error", err.Error()))
+	}
+}
+
+// * keep own lease alive, reclaim lists of instances whose lease expired
+func heartbeat(ctx context.Context) {
+	defer wg.Done()
+
+	ticker := time.NewTicker(leaseTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			renewLease()
+			reclaim()
+		}
+	}
+}
+
+// * jobs of a crashed or restarted instance go back to the head of the queue
+func reclaim() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	iter := rdb.Scan(ctx, 0, processingPrefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		owner := strings.TrimPrefix(key, processingPrefix)
+		if i := strings.LastIndexByte(owner, ':'); i >= 0 {
+			owner = owner[:i]
+		}
+		if owner == instanceID {
+			continue
+		}
+		alive, err := rdb.Exists(ctx, leasePrefix+owner).Result()
+		if err != nil || alive > 0 {
+			continue
+		}
+
+		for {
+			id, err := rdb.LMove(ctx, key, queueKey, "RIGHT", "LEFT").Result()
+			if err != nil {
+				if err != redis.Nil {
+					slog.Error("failed to requeue job", slog.String("error", err.Error()))
+				}
+				break
+			}
+			rdb.HSet(ctx, jobKey(id), "status", StatusQueued)
+			slog.Warn("requeue interrupted job", slog.String("id", id), slog.String("instance", owner))
+		}
+	}
+	if err := iter.Err(); err != nil {
+		slog.Error("failed to scan processing jobs", slog.String("error", err.Error()))
+	}
+}
+
+// * stop taking new jobs and wait running jobs
+func Stop() {
+	if cancel == nil {
+		return
+	}
+	cancel()
+	wg.Wait()
+
+	// * nothing left in own lists, drop the lease at once
+	ctx, cancelDel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelDel()
+	rdb.Del(ctx, leasePrefix+instanceID)
+}
+
+func Enqueue(ctx context.Context, job *Job) error {
+	if rdb == nil {
+		return ErrDisabled
+	}
+
+	id, err := utils.NewID()
+	if err != nil {
+		return fmt.Errorf("failed to create job id: %w", err)
+	}
+	job.ID = id
+	job.Status = StatusQueued
+	job.CreatedAt = time.Now().Unix()
+
+	key := jobKey(job.ID)
+	pipe := rdb.TxPipeline()
+	pipe.HSet(ctx, key, map[string]interface{}{
+		"path":       job.Path,
+		"version":    job.Version,
+		"alias":      job.Alias,
+		"subject":    job.Subject,
+		"options":    string(job.Options),
+		"input":      job.Input,
+		"status":     job.Status,
+		"created_at": job.CreatedAt,
+	})
+	pipe.Expire(ctx, key, jobTTL)
+	pipe.RPush(ctx, queueKey, job.ID)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to enqueue job: %w", err)
+	}
+	return nil
+}
+
+func Get(ctx context.Context, id string) (*Job, error) {
+	if rdb == nil {
+		return nil, ErrDisabled
+	}
+
+	data, err := rdb.HGetAll(ctx, jobKey(id)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get job: %w", err)
+	}
+	if len(data) == 0 {
+		return nil, ErrJobNotFound
+	}
+
+	version, _ := strconv.ParseInt(data["version"], 10, 64)
+	createdAt, _ := strconv.ParseInt(data["created_at"], 10, 64)
+	startedAt, _ := strconv.ParseInt(data["started_at"], 10, 64)
+	finishedAt, _ := strconv.ParseInt(data["finished_at"], 10, 64)
+	requeues, _ := strconv.Atoi(data["requeues"])
+	return &Job{
+		ID:         id,
+		Path:       data["path"],
+		Version:    version,
+		Alias:      data["alias"],
+		Subject:    data["subject"],
+		Options:    json.RawMessage(data["options"]),
+		Input:      data["input"],
+		Status:     data["status"],
+		Output:     data["output"],
+		Error:      data["error"],
+		Requeues:   requeues,
+		CreatedAt:  createdAt,
+		StartedAt:  startedAt,
+		FinishedAt: finishedAt,
+	}, nil
+}
+
+func worker(ctx context.Context, processingKey string) {
+	defer wg.Done()
+
+	for {
+		// * job stays in processing list until finished, block with short timeout
+		id, err := rdb.BLMove(ctx, queueKey, processingKey, "LEFT", "RIGHT", 5*time.Second).Result()
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			if err != redis.Nil {
+				slog.Error("failed to pop job", slog.String("error", err.Error()))
+				time.Sleep(time.Second)
+			}
+			continue
+		}
+
+		process(ctx, id, processingKey)
+	}
+}
+
+func process(workerCtx context.Context, id, processingKey string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	job, err := Get(ctx, id)
+	if err != nil {
+		// * job expired before picked
+		slog.Warn("failed to load job", slog.String("id", id), slog.String("error", err.Error()))
+		rdb.LRem(ctx, processingKey, 1, id)
+		return
+	}
+
+	key := jobKey(id)
+	job.StartedAt = time.Now().Unix()
+	if err := rdb.HSet(ctx, key, "status", StatusRunning, "started_at", job.StartedAt).Err(); err != nil {
+		slog.Error("failed to update job", slog.String("id", id), slog.String("error", err.Error()))
+	}
+
+	output, version, runErr := execute(job)
+
+	var requeueErr *RequeueError
+	if errors.As(runErr, &requeueErr) {
+		if job.Requeues < maxRequeue {
+			requeue(workerCtx, job, processingKey, requeueErr)
+			return
+		}
+		runErr = fmt.Errorf("gave up after %d requeues: %w", job.Requeues, runErr)
+	}
+
+	// * new context, execution may take longer than the one above
+	saveCtx, saveCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer saveCancel()
+
+	fields := map[string]interface{}{
+		"status":      StatusSucceeded,
+		"version":     version,
+		"output":      output,
+		"finished_at": time.Now().Unix(),
+	}
+	if runErr != nil {
+		fields["status"] = StatusFailed
+		fields["error"] = runErr.Error()
+	}
+
+	pipe := rdb.TxPipeline()
+	pipe.HSet(saveCtx, key, fields)
+	pipe.HDel(saveCtx, key, "input")
+	pipe.Expire(saveCtx, key, jobTTL)
+	pipe.LRem(saveCtx, processingKey, 1, id)
+	if _, err := pipe.Exec(saveCtx); err != nil {
+		slog.Error("failed to save job result", slog.String("id", id), slog.String("error", err.Error()))
+	}
+}
+
+// * wait before pushing back, a saturated admission is not polled in a tight loop
+// * job stays in the processing list meanwhile, shutdown cuts the wait short
+func requeue(ctx context.Context, job *Job, processingKey string, requeueErr *RequeueError) {
+	slog.Warn("requeue job",
+		slog.String("id", job.ID),
+		slog.Int("requeues", job.Requeues+1),
+		slog.Duration("delay", requeueErr.Delay),
+		slog.String("error", requeueErr.Error()),
+	)
+
+	timer := time.NewTimer(requeueErr.Delay)
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+	timer.Stop()
+
+	saveCtx, saveCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer saveCancel()
+
+	key := jobKey(job.ID)
+	pipe := rdb.TxPipeline()
+	pipe.HSet(saveCtx, key, "status", StatusQueued, "requeues", job.Requeues+1)
+	pipe.HDel(saveCtx, key, "started_at")
+	pipe.LRem(saveCtx, processingKey, 1, job.ID)
+	pipe.RPush(saveCtx, queueKey, job.ID)
+	if _, err := pipe.Exec(saveCtx); err != nil {
+		slog.Error("failed to requeue job", slog.String("id", job.ID), slog.String("error", err.Error()))
+	}
+}
+
+func jobKey(id string) string {
+	return fmt.Sprintf("job:%s", id)
+}
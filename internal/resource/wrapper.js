@@ -1,29 +1,77 @@
 #!/usr/bin/env node
 
+const fs = require('fs');
 const vm = require('vm');
 
-// Read stdin (JSON payload with code and input)
+// Write one JSON frame to fd 3, absent when not run by go-faas
+function send(frame) {
+  try {
+    const buf = Buffer.from(JSON.stringify(frame) + '\n');
+    let offset = 0;
+    while (offset < buf.length) {
+      offset += fs.writeSync(3, buf, offset);
+    }
+    return true;
+  } catch (e) {
+    return false;
+  }
+}
+
+// Read the first stdin line (JSON payload with code and input),
+// the rest of stdin stays readable by user code
 let inputData = '';
 process.stdin.setEncoding('utf8');
 
-process.stdin.on('data', (chunk) => {
-  inputData += chunk;
-});
+function onReadable() {
+  let chunk;
+  while ((chunk = process.stdin.read()) !== null) {
+    inputData += chunk;
+    const i = inputData.indexOf('\n');
+    if (i < 0) {
+      continue;
+    }
+    const rest = inputData.slice(i + 1);
+    inputData = inputData.slice(0, i);
+    process.stdin.removeListener('readable', onReadable);
+    process.stdin.removeListener('end', run);
+    if (rest) {
+      process.stdin.unshift(rest);
+    }
+    run();
+    return;
+  }
+}
+
+process.stdin.on('readable', onReadable);
+process.stdin.on('end', run);
 
-process.stdin.on('end', () => {
+function run() {
   try {
     // Parse payload JSON
     const payload = inputData ? JSON.parse(inputData) : {};
     const code = payload.code || '';
     const inputStr = payload.input || '';
+    const contentType = payload.content_type || '';
+
+    // Function env is delivered in the payload, never on the host side
+    Object.assign(process.env, payload.env || {});
 
-    // Parse input JSON
-    const event = inputStr ? JSON.parse(inputStr) : {};
+    // Parse input JSON, raw request body is passed as is (Buffer when binary)
+    let event;
+    if (contentType) {
+      event = payload.is_base64 ? Buffer.from(inputStr, 'base64') : inputStr;
+    } else {
+      event = inputStr ? JSON.parse(inputStr) : {};
+    }
     const input = event;
 
     // Make event and input available globally
     global.event = event;
     global.input = input;
+    global.content_type = contentType;
+
+    // Signal ready before running user code
+    send({ type: 'ready' });
 
     // Execute user script wrapped so top-level `return` works
     try {
@@ -31,9 +79,11 @@ process.stdin.on('end', () => {
       const scriptObj = new vm.Script(wrapped, { filename: 'user-code.js' });
       const context = vm.createContext(global);
       Promise.resolve(scriptObj.runInContext(context)).then((res) => {
-        if (typeof res !== 'undefined') {
+        if (typeof res !== 'undefined' && !send({ type: 'result', data: res })) {
           console.log(JSON.stringify(res));
         }
+        // Stop reading stdin so the process exits once the function returns
+        process.stdin.destroy();
       }).catch((err) => {
         console.error('Error:', err && err.message ? err.message : String(err));
         process.exit(1);
@@ -46,4 +96,4 @@ process.stdin.on('end', () => {
     console.error('Error:', error.message);
     process.exit(1);
   }
-});
+}
@@ -1,24 +1,49 @@
 #!/usr/bin/env python3
 
+import base64
+import os
 import sys
 import json
 
-# Read stdin (JSON payload with code and input)
-input_data = sys.stdin.read()
+
+def send(frame):
+    # Write one JSON frame to fd 3, absent when not run by go-faas
+    try:
+        with os.fdopen(3, 'w', closefd=False) as channel:
+            channel.write(json.dumps(frame) + '\n')
+        return True
+    except OSError:
+        return False
+
+
+# Read the first stdin line (JSON payload with code and input),
+# the rest of stdin stays readable by user code
+input_data = sys.stdin.readline()
 
 try:
     # Parse payload JSON
     payload = json.loads(input_data) if input_data.strip() else {}
     code = payload.get('code', '')
     input_str = payload.get('input', '')
+    content_type = payload.get('content_type', '')
+
+    # Function env is delivered in the payload, never on the host side
+    os.environ.update(payload.get('env') or {})
     
-    # Parse input JSON
-    event = json.loads(input_str) if input_str.strip() else {}
+    # Parse input JSON, raw request body is passed as is (bytes when binary)
+    if content_type:
+        event = base64.b64decode(input_str) if payload.get('is_base64') else input_str
+    else:
+        event = json.loads(input_str) if input_str.strip() else {}
     input_var = event
 
     # Make event and input available globally
     globals()['event'] = event
     globals()['input'] = input_var
+    globals()['content_type'] = content_type
+
+    # Signal ready before running user code
+    send({'type': 'ready'})
 
     # Execute user script wrapped in a function so top-level `return` works
     func_code = 'def __user_main__():\n'
@@ -32,18 +57,17 @@ except Exception as e:
     print(f'Error: {str(e)}', file=sys.stderr)
     sys.exit(1)
 
-# If the script returned a value or set a `result`/`__return__` global, print it as JSON
+# Send the returned value or a `result`/`__return__` global as the result
 try:
     if 'result' in globals():
-        print(json.dumps(globals()['result']))
+        value = globals()['result']
     elif '__return__' in globals():
-        print(json.dumps(globals()['__return__']))
+        value = globals()['__return__']
     else:
-        # prefer returned value if available
-        try:
-            print(json.dumps(result))
-        except Exception:
-            pass
+        value = result
+    frame = {'type': 'result', 'data': value}
+    if not send(frame):
+        print(json.dumps(value))
 except Exception:
     # ignore serialization errors; leave any prints as-is
     pass
@@ -1,32 +1,80 @@
 #!/usr/bin/env tsx
 
 import { createRequire } from 'module';
+import * as fs from 'fs';
 import * as vm from 'vm';
 
 const require = createRequire(import.meta.url);
 
-// Read stdin (JSON payload with code and input)
+// Write one JSON frame to fd 3, absent when not run by go-faas
+function send(frame: any): boolean {
+  try {
+    const buf = Buffer.from(JSON.stringify(frame) + '\n');
+    let offset = 0;
+    while (offset < buf.length) {
+      offset += fs.writeSync(3, buf, offset);
+    }
+    return true;
+  } catch (e) {
+    return false;
+  }
+}
+
+// Read the first stdin line (JSON payload with code and input),
+// the rest of stdin stays readable by user code
 let inputData = '';
 process.stdin.setEncoding('utf8');
 
-process.stdin.on('data', (chunk) => {
-  inputData += chunk;
-});
+function onReadable() {
+  let chunk: string | null;
+  while ((chunk = process.stdin.read()) !== null) {
+    inputData += chunk;
+    const i = inputData.indexOf('\n');
+    if (i < 0) {
+      continue;
+    }
+    const rest = inputData.slice(i + 1);
+    inputData = inputData.slice(0, i);
+    process.stdin.removeListener('readable', onReadable);
+    process.stdin.removeListener('end', run);
+    if (rest) {
+      process.stdin.unshift(rest);
+    }
+    run();
+    return;
+  }
+}
+
+process.stdin.on('readable', onReadable);
+process.stdin.on('end', run);
 
-process.stdin.on('end', async () => {
+async function run() {
   try {
     // Parse payload JSON
     const payload = inputData ? JSON.parse(inputData) : {};
     const code = payload.code || '';
     const inputStr = payload.input || '';
+    const contentType = payload.content_type || '';
+
+    // Function env is delivered in the payload, never on the host side
+    Object.assign(process.env, payload.env || {});
 
-    // Parse input JSON
-    const event = inputStr ? JSON.parse(inputStr) : {};
+    // Parse input JSON, raw request body is passed as is (Buffer when binary)
+    let event: any;
+    if (contentType) {
+      event = payload.is_base64 ? Buffer.from(inputStr, 'base64') : inputStr;
+    } else {
+      event = inputStr ? JSON.parse(inputStr) : {};
+    }
     const input = event;
 
     // Make event and input available globally
     (global as any).event = event;
     (global as any).input = input;
+    (global as any).content_type = contentType;
+
+    // Signal ready before running user code
+    send({ type: 'ready' });
 
     // Execute user script: compile TypeScript then run with vm
     try {
@@ -62,10 +110,13 @@ process.stdin.on('end', async () => {
   try {
     const g: any = globalThis as any;
     const res = g.result ?? g.__return__;
-    if (typeof res !== 'undefined') {
+    if (typeof res !== 'undefined' && !send({ type: 'result', data: res })) {
       console.log(JSON.stringify(res));
     }
   } catch (e) {
     // ignore
   }
-});
+
+  // Stop reading stdin so the process exits once the function returns
+  process.stdin.destroy();
+}
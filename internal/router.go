@@ -5,6 +5,7 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/pardnchiu/go-faas/internal/auth"
 	"github.com/pardnchiu/go-faas/internal/handler"
 	"github.com/pardnchiu/go-faas/internal/utils"
 )
@@ -14,9 +15,39 @@ func CreateServer() *http.Server {
 
 	r := gin.Default()
 
-	r.POST("/upload", handler.Upload)
-	r.POST("/run/*targetPath", handler.Run)
-	r.POST("/run-now", handler.RunNow)
+	// * AUTH_MODE picks api key, jwt bearer, or both
+	upload := auth.Require(auth.ScopeUpload)
+	run := auth.Require(auth.ScopeRun)
+	runNow := auth.Require(auth.ScopeRunNow)
+	admin := auth.Require(auth.ScopeAdmin)
+
+	r.POST("/upload", upload, handler.Upload)
+	r.POST("/run/*targetPath", run, handler.Run)
+	r.POST("/run-now", runNow, handler.RunNow)
+	r.POST("/run-async/*targetPath", run, handler.RunAsync)
+	r.GET("/jobs/:id", run, handler.GetJob)
+	r.GET("/streams/:id", auth.Require(auth.ScopeRun, auth.ScopeRunNow), handler.GetStream)
+	r.GET("/ws/run", auth.Require(auth.ScopeRun, auth.ScopeRunNow), handler.RunWS)
+	r.Any("/fn/*targetPath", run, handler.Trigger)
+
+	r.GET("/functions", upload, handler.ListFunctions)
+	r.GET("/functions/*targetPath", upload, handler.GetFunction)
+	r.POST("/functions/*targetPath", upload, handler.PostFunction)
+	r.PUT("/functions/*targetPath", upload, handler.PutFunction)
+	r.DELETE("/functions/*targetPath", upload, handler.DeleteFunction)
+
+	r.POST("/schedules", run, handler.CreateSchedule)
+	r.GET("/schedules", run, handler.ListSchedules)
+	r.GET("/schedules/:id", run, handler.GetSchedule)
+	r.DELETE("/schedules/:id", run, handler.DeleteSchedule)
+
+	r.GET("/runs", admin, handler.ListRuns)
+	r.DELETE("/runs/:id", admin, handler.CancelRun)
+	r.GET("/metrics", admin, handler.GetMetrics)
+
+	r.POST("/keys", admin, handler.CreateKey)
+	r.GET("/keys", admin, handler.ListKeys)
+	r.DELETE("/keys/:id", admin, handler.DeleteKey)
 
 	return &http.Server{
 		Addr:    fmt.Sprintf(":%d", port),
@@ -0,0 +1,101 @@
+package sandbox
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// * fd 3 inside sandbox, wrapper writes one JSON frame per line
+// * {"type":"ready"} before user code, {"type":"result","data":...} after
+type Channel struct {
+	r, w      *os.File
+	begin     time.Time
+	ready     time.Time
+	result    string
+	hasResult bool
+	done      chan struct{}
+}
+
+type frame struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// * attach fd 3 to command, call before Start
+func NewChannel(cmd *exec.Cmd) (*Channel, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd.ExtraFiles = append(cmd.ExtraFiles, w)
+	return &Channel{r: r, w: w, done: make(chan struct{})}, nil
+}
+
+// * start command and read frames until wrapper exits
+func (ch *Channel) Start(cmd *exec.Cmd) error {
+	ch.begin = time.Now()
+	err := cmd.Start()
+	// * child holds its own copy
+	ch.w.Close()
+	if err != nil {
+		ch.r.Close()
+		return err
+	}
+
+	go ch.read()
+	return nil
+}
+
+func (ch *Channel) read() {
+	defer close(ch.done)
+	defer ch.r.Close()
+
+	reader := bufio.NewReader(ch.r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			var f frame
+			if json.Unmarshal(line, &f) == nil {
+				switch f.Type {
+				case "ready":
+					ch.ready = time.Now()
+				case "result":
+					ch.result = "null"
+					if len(f.Data) > 0 {
+						ch.result = string(f.Data)
+					}
+					ch.hasResult = true
+				}
+			}
+		}
+		if err != nil {
+			if err != io.EOF {
+				io.Copy(io.Discard, ch.r)
+			}
+			return
+		}
+	}
+}
+
+func (ch *Channel) Begin() time.Time {
+	return ch.begin
+}
+
+// * time from spawn to wrapper ready, zero when wrapper never signaled
+func (ch *Channel) Startup() time.Duration {
+	<-ch.done
+	if ch.ready.IsZero() {
+		return 0
+	}
+	return ch.ready.Sub(ch.begin)
+}
+
+// * returned value as JSON, false when function returned nothing
+func (ch *Channel) Result() (string, bool) {
+	<-ch.done
+	return ch.result, ch.hasResult
+}
@@ -6,6 +6,8 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+
+	"github.com/pardnchiu/go-faas/internal/utils"
 )
 
 var (
@@ -19,15 +21,84 @@ var (
 		"javascript": "node",
 		"typescript": "tsx",
 	}
+	// * host env systemd-run needs to reach the user manager, dropped again inside bwrap
+	// * function env never enters host side processes, wrapper sets it from the payload
+	hostEnv = []string{"PATH", "XDG_RUNTIME_DIR", "DBUS_SESSION_BUS_ADDRESS"}
 )
 
-func SandboxCommand(ctx context.Context, lang string) (*exec.Cmd, error) {
+// * per-invocation limits inside the shared slice, zero value uses scope defaults
+type Limit struct {
+	Memory string
+	CPU    float64
+	Tasks  int
+	Swap   string
+}
+
+// * systemd-run scope properties, clamped by slice ceiling
+func (l Limit) properties() []string {
+	var props []string
+
+	if size, ok := l.MemoryMax(); ok {
+		props = append(props, "-p", fmt.Sprintf("MemoryMax=%d", size))
+	}
+
+	swap := l.Swap
+	if swap == "" {
+		swap = getDefaultSwap()
+	}
+	if size, ok := clampSize(swap, getMaxSwap()); ok {
+		props = append(props, "-p", fmt.Sprintf("MemorySwapMax=%d", size))
+	}
+
+	cpu := l.CPU
+	if cpu <= 0 {
+		cpu = getDefaultCPU()
+	}
+	cpu = min(cpu, float64(getMaxCPU()))
+	props = append(props, "-p", fmt.Sprintf("CPUQuota=%d%%", max(int(cpu*100), 1)))
+
+	tasks := l.Tasks
+	if tasks <= 0 {
+		tasks = getDefaultTasks()
+	}
+	props = append(props, "-p", fmt.Sprintf("TasksMax=%d", min(tasks, getMaxTasks())))
+
+	return props
+}
+
+// * effective MemoryMax of the scope, false when the size cannot be parsed
+func (l Limit) MemoryMax() (int64, bool) {
+	memory := l.Memory
+	if memory == "" {
+		memory = getDefaultMemory()
+	}
+	return clampSize(memory, getMaxMemory())
+}
+
+func clampSize(value, ceiling string) (int64, bool) {
+	size, err := utils.ParseSize(value)
+	if err != nil {
+		return 0, false
+	}
+	if maxSize, err := utils.ParseSize(ceiling); err == nil {
+		size = min(size, maxSize)
+	}
+	return size, true
+}
+
+// * one transient scope per invocation, named so its cgroup can be found
+func SandboxCommand(ctx context.Context, lang string, limit Limit) (*exec.Cmd, *Scope, error) {
 	runtime := runtimeMap[lang]
 	ext := extMap[lang]
 
 	wd, err := os.Getwd()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	scope, err := newScope()
+	if err != nil {
+		return nil, nil, err
 	}
 
 	wrapperPath := filepath.Join(wd, "internal", "resource", fmt.Sprintf("wrapper%s", ext))
@@ -55,6 +126,8 @@ func SandboxCommand(ctx context.Context, lang string) (*exec.Cmd, error) {
 		"--setenv", "LANG", "C.UTF-8",
 		"--unsetenv", "LD_PRELOAD",
 		"--unsetenv", "LD_LIBRARY_PATH",
+		"--unsetenv", "XDG_RUNTIME_DIR",
+		"--unsetenv", "DBUS_SESSION_BUS_ADDRESS",
 	}
 
 	if lang == "typescript" {
@@ -76,11 +149,26 @@ func SandboxCommand(ctx context.Context, lang string) (*exec.Cmd, error) {
 
 	args := []string{
 		"--scope", "--user", "--quiet",
-		"--slice=go-faas-slice",
-		"--",
-		"bwrap",
+		"--slice=" + sliceName,
+		"--unit=" + scope.Unit,
+		"--description=" + scope.description(),
 	}
-	args = append(args, baseArgs...)
+	args = append(args, limit.properties()...)
+	args = append(args, "--")
+	args = append(args, scope.wrap(append([]string{"bwrap"}, baseArgs...)...)...)
 
-	return exec.CommandContext(ctx, "systemd-run", args...), nil
+	cmd := exec.CommandContext(ctx, "systemd-run", args...)
+	cmd.Env = make([]string, 0, len(hostEnv))
+	for _, key := range hostEnv {
+		if value, ok := os.LookupEnv(key); ok {
+			cmd.Env = append(cmd.Env, key+"="+value)
+		}
+	}
+	// * context done terminates the whole scope, not only the systemd-run process
+	cmd.Cancel = func() error {
+		return scope.Terminate(cmd.Process)
+	}
+	// * leftover holder of stdout / stderr can not block Wait forever
+	cmd.WaitDelay = getGrace() + systemctlTimeout
+	return cmd, scope, nil
 }
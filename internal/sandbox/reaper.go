@@ -0,0 +1,97 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// * stop scopes left by a previous process, scopes of a live instance are kept
+func ReapScopes() {
+	out, err := systemctlOutput("list-units", "--all", "--plain", "--no-legend", "--type=scope", scopePrefix+"*.scope")
+	if err != nil {
+		slog.Warn("failed to list sandbox scopes", slog.String("error", err.Error()))
+		return
+	}
+
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || !strings.HasPrefix(fields[0], scopePrefix) {
+			continue
+		}
+		unit := fields[0]
+
+		description, err := systemctlOutput("show", "--property=Description", "--value", unit)
+		if err != nil || ownerAlive(strings.TrimSpace(description)) {
+			continue
+		}
+
+		_ = systemctl("kill", "--signal=SIGKILL", unit)
+		if err := systemctl("stop", unit); err != nil {
+			slog.Error("failed to reap sandbox scope",
+				slog.String("unit", unit),
+				slog.String("error", err.Error()),
+			)
+			continue
+		}
+		slog.Info("reaped orphaned sandbox scope", slog.String("unit", unit))
+	}
+}
+
+// * description "go-faas pid=<pid> start=<ticks>", missing owner counts as orphan
+func ownerAlive(description string) bool {
+	value, ok := strings.CutPrefix(description, ownerPrefix+" pid=")
+	if !ok {
+		return false
+	}
+	value, start, hasStart := strings.Cut(value, " start=")
+	pid, err := strconv.Atoi(value)
+	if err != nil {
+		return false
+	}
+
+	// * reused pid runs another process, start time tells them apart
+	if hasStart {
+		current, ok := processStart(pid)
+		return ok && current == start
+	}
+
+	// * scope from an older build without start time
+	if pid == os.Getpid() {
+		return false
+	}
+	err = syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// * starttime of /proc/<pid>/stat in clock ticks since boot, field 22
+func processStart(pid int) (string, bool) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return "", false
+	}
+	// * comm may hold spaces and parentheses, fields follow the last ")"
+	i := strings.LastIndexByte(string(data), ')')
+	if i < 0 {
+		return "", false
+	}
+	fields := strings.Fields(string(data[i+1:]))
+	if len(fields) < 20 {
+		return "", false
+	}
+	return fields[19], true
+}
+
+func systemctlOutput(args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), systemctlTimeout)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, "systemctl", append([]string{"--user"}, args...)...).Output()
+	return string(out), err
+}
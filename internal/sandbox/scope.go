@@ -0,0 +1,294 @@
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pardnchiu/go-faas/internal/utils"
+)
+
+const (
+	scopePrefix = "go-faas-"
+	ownerPrefix = "go-faas"
+	cgroupRoot  = "/sys/fs/cgroup"
+	usagePoll   = 100 * time.Millisecond
+
+	// * runs inside the scope around bwrap, the shell keeps the cgroup alive
+	// * so its final counters can be dumped after bwrap exits
+	// * TERM is trapped rather than ignored, bwrap keeps the default action
+	usageScript = `trap : TERM INT
+out=$1
+shift
+"$@"
+status=$?
+while IFS= read -r line; do
+	case $line in 0::*) cg=%[1]s${line#0::} ;; esac
+done < /proc/self/cgroup
+for f in %[2]s; do
+	if [ -r "$cg/$f" ]; then printf '# %%s\n' "$f"; cat "$cg/$f"; fi
+done > "$out.tmp"
+mv "$out.tmp" "$out"
+exit $status`
+)
+
+// * cgroup files of usage, peak files need newer kernels and fall back to current
+var usageFiles = []string{"cpu.stat", "memory.peak", "memory.current", "memory.events", "pids.peak", "pids.current"}
+
+// * resource usage read from scope cgroup, zero when cgroup v2 unavailable
+type Usage struct {
+	MemoryPeak    int64 `json:"memory_peak_bytes"`
+	CPUUsage      int64 `json:"cpu_usage_us"`
+	CPUUser       int64 `json:"cpu_user_us"`
+	CPUSystem     int64 `json:"cpu_system_us"`
+	Throttled     int64 `json:"nr_throttled"`
+	ThrottledTime int64 `json:"throttled_us"`
+	PidsPeak      int64 `json:"pids_peak"`
+	OOMKills      int64 `json:"oom_kills"`
+}
+
+// * transient scope of one invocation, id doubles as run id
+type Scope struct {
+	ID   string
+	Unit string
+
+	mu    sync.Mutex
+	path  string
+	usage Usage
+	stop  chan struct{}
+	done  chan struct{}
+	// * final counters dumped by usageScript
+	usageFile string
+
+	terminateOnce sync.Once
+	terminateErr  error
+}
+
+func newScope() (*Scope, error) {
+	id, err := utils.NewID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate scope name: %w", err)
+	}
+	dir, err := usageDir()
+	if err != nil {
+		return nil, err
+	}
+	return &Scope{
+		ID:        id,
+		Unit:      scopePrefix + id + ".scope",
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+		usageFile: filepath.Join(dir, id),
+	}, nil
+}
+
+// * private to the server user, the dump is trusted for oom detection
+func usageDir() (string, error) {
+	base := os.Getenv("XDG_RUNTIME_DIR")
+	if base == "" {
+		cache, err := os.UserCacheDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to find usage folder: %w", err)
+		}
+		base = cache
+	}
+	dir := filepath.Join(base, "go-faas", "usage")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create usage folder: %w", err)
+	}
+	return dir, nil
+}
+
+// * shell wrapper placed between systemd-run and bwrap
+func (s *Scope) wrap(command ...string) []string {
+	script := fmt.Sprintf(usageScript, cgroupRoot, strings.Join(usageFiles, " "))
+	return append([]string{"/bin/sh", "-c", script, ownerPrefix, s.usageFile}, command...)
+}
+
+// * sample cgroup while running, systemd removes it as soon as scope is empty
+func (s *Scope) Watch(pid int) {
+	go func() {
+		defer close(s.done)
+
+		ticker := time.NewTicker(usagePoll)
+		defer ticker.Stop()
+
+		for {
+			s.sample(pid)
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// * stop sampling after command exited, final counters come from the dump
+// * samples remain when the wrapper was killed before writing it
+func (s *Scope) Usage() Usage {
+	select {
+	case <-s.stop:
+	default:
+		close(s.stop)
+	}
+	<-s.done
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if b, err := os.ReadFile(s.usageFile); err == nil {
+		if usage, ok := parseUsage(splitDump(string(b))); ok {
+			s.usage = s.usage.merge(usage)
+		}
+	}
+	os.Remove(s.usageFile)
+	os.Remove(s.usageFile + ".tmp")
+
+	if s.path != "" {
+		if usage, ok := readUsage(s.path); ok {
+			s.usage = s.usage.merge(usage)
+		}
+	}
+	return s.usage
+}
+
+// * usage so far, sampling keeps running
+func (s *Scope) Snapshot() Usage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.usage
+}
+
+// * owner pid and start time let reaper tell orphans from scopes of a live instance
+func (s *Scope) description() string {
+	start, _ := processStart(os.Getpid())
+	return fmt.Sprintf("%s pid=%d start=%s", ownerPrefix, os.Getpid(), start)
+}
+
+func (s *Scope) sample(pid int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// * systemd-run moves itself into scope before exec
+	if s.path == "" {
+		s.path = findCgroup(pid, s.Unit)
+		if s.path == "" {
+			return
+		}
+	}
+	if usage, ok := readUsage(s.path); ok {
+		s.usage = s.usage.merge(usage)
+	}
+}
+
+// * counters only grow, peaks keep the highest sample
+func (u Usage) merge(other Usage) Usage {
+	return Usage{
+		MemoryPeak:    max(u.MemoryPeak, other.MemoryPeak),
+		CPUUsage:      max(u.CPUUsage, other.CPUUsage),
+		CPUUser:       max(u.CPUUser, other.CPUUser),
+		CPUSystem:     max(u.CPUSystem, other.CPUSystem),
+		Throttled:     max(u.Throttled, other.Throttled),
+		ThrottledTime: max(u.ThrottledTime, other.ThrottledTime),
+		PidsPeak:      max(u.PidsPeak, other.PidsPeak),
+		OOMKills:      max(u.OOMKills, other.OOMKills),
+	}
+}
+
+func findCgroup(pid int, unit string) string {
+	b, err := os.ReadFile(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		// * cgroup v2 unified line "0::/path"
+		path, ok := strings.CutPrefix(line, "0::")
+		if ok && filepath.Base(path) == unit {
+			return filepath.Join(cgroupRoot, path)
+		}
+	}
+	return ""
+}
+
+func readUsage(path string) (Usage, bool) {
+	files := map[string]string{}
+	for _, name := range usageFiles {
+		if b, err := os.ReadFile(filepath.Join(path, name)); err == nil {
+			files[name] = string(b)
+		}
+	}
+	return parseUsage(files)
+}
+
+// * "# name" line before each file content
+func splitDump(dump string) map[string]string {
+	files := map[string]string{}
+	var name string
+	for _, line := range strings.Split(dump, "\n") {
+		if n, ok := strings.CutPrefix(line, "# "); ok {
+			name = n
+			continue
+		}
+		if name != "" {
+			files[name] += line + "\n"
+		}
+	}
+	return files
+}
+
+func parseUsage(files map[string]string) (Usage, bool) {
+	raw, ok := files["cpu.stat"]
+	if !ok {
+		return Usage{}, false
+	}
+
+	stat := parseKeyed(raw)
+	usage := Usage{
+		CPUUsage:      stat["usage_usec"],
+		CPUUser:       stat["user_usec"],
+		CPUSystem:     stat["system_usec"],
+		Throttled:     stat["nr_throttled"],
+		ThrottledTime: stat["throttled_usec"],
+	}
+
+	usage.MemoryPeak = parseValue(files, "memory.peak", "memory.current")
+	usage.PidsPeak = parseValue(files, "pids.peak", "pids.current")
+	if events, ok := files["memory.events"]; ok {
+		usage.OOMKills = parseKeyed(events)["oom_kill"]
+	}
+	return usage, true
+}
+
+func parseValue(files map[string]string, names ...string) int64 {
+	for _, name := range names {
+		raw, ok := files[name]
+		if !ok {
+			continue
+		}
+		if n, err := strconv.ParseInt(strings.TrimSpace(raw), 10, 64); err == nil {
+			return n
+		}
+	}
+	return 0
+}
+
+// * "key value" per line, as cpu.stat and memory.events
+func parseKeyed(raw string) map[string]int64 {
+	values := map[string]int64{}
+	for _, line := range strings.Split(raw, "\n") {
+		key, value, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			values[key] = n
+		}
+	}
+	return values
+}
@@ -9,9 +9,47 @@ import (
 	"github.com/pardnchiu/go-faas/internal/utils"
 )
 
+// * systemd-run appends .slice suffix
+const sliceName = "go-faas-slice"
+
+func getMaxCPU() int {
+	return utils.GetWithDefaultInt("MAX_CPUS", 1)
+}
+
+func getMaxMemory() string {
+	return utils.GetWithDefault("MAX_MEMORY", "128M")
+}
+
+func getMaxTasks() int {
+	return utils.GetWithDefaultInt("MAX_TASKS", 512)
+}
+
+func getMaxSwap() string {
+	return utils.GetWithDefault("MAX_SWAP", "0")
+}
+
+// * per-invocation defaults, each scope gets its own share of the slice
+func getDefaultMemory() string {
+	return utils.GetWithDefault("DEFAULT_MEMORY", getMaxMemory())
+}
+
+func getDefaultCPU() float64 {
+	return utils.GetWithDefaultFloat("DEFAULT_CPUS", float64(getMaxCPU()))
+}
+
+func getDefaultTasks() int {
+	return utils.GetWithDefaultInt("DEFAULT_TASKS", 64)
+}
+
+func getDefaultSwap() string {
+	return utils.GetWithDefault("DEFAULT_SWAP", "0")
+}
+
 func NewSlice() error {
-	maxCPU := utils.GetWithDefaultInt("MAX_CPUS", 1)
-	maxMemory := utils.GetWithDefault("MAX_MEMORY", "128M")
+	maxCPU := getMaxCPU()
+	maxMemory := getMaxMemory()
+	maxTasks := getMaxTasks()
+	maxSwap := getMaxSwap()
 
 	sliceContent := fmt.Sprintf(`[Unit]
 Description=FaaS Sandbox
@@ -19,19 +57,20 @@ Description=FaaS Sandbox
 [Slice]
 CPUQuota=%d%%
 MemoryMax=%s
-MemorySwapMax=0
-`, maxCPU*100, maxMemory)
+MemorySwapMax=%s
+TasksMax=%d
+`, maxCPU*100, maxMemory, maxSwap, maxTasks)
 
 	folderPath := filepath.Join(os.Getenv("HOME"), ".config/systemd/user")
 	os.MkdirAll(folderPath, 0755)
 
-	path := filepath.Join(folderPath, "go-faas-slice")
+	path := filepath.Join(folderPath, sliceName+".slice")
 	if err := os.WriteFile(path, []byte(sliceContent), 0644); err != nil {
 		return err
 	}
 
 	exec.Command("systemctl", "--user", "daemon-reload").Run()
-	exec.Command("systemctl", "--user", "start", "go-faas-slice").Run()
+	exec.Command("systemctl", "--user", "start", sliceName+".slice").Run()
 
 	return nil
 }
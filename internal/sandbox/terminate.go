@@ -0,0 +1,104 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pardnchiu/go-faas/internal/utils"
+)
+
+const (
+	terminatePoll    = 50 * time.Millisecond
+	systemctlTimeout = 5 * time.Second
+)
+
+func getGrace() time.Duration {
+	return time.Duration(utils.GetWithDefaultInt("TIMEOUT_GRACE_SECONDS", 3)) * time.Second
+}
+
+// * SIGTERM to every process in scope, stop the whole scope after grace period
+// * safe to call more than once, later calls wait for the first
+func (s *Scope) Terminate(proc *os.Process) error {
+	s.terminateOnce.Do(func() {
+		s.terminateErr = s.terminate(proc)
+	})
+	return s.terminateErr
+}
+
+func (s *Scope) terminate(proc *os.Process) error {
+	// * systemd-run execs bwrap, signal alone does not reach code behind --die-with-parent
+	if err := systemctl("kill", "--signal=SIGTERM", s.Unit); err != nil {
+		_ = proc.Signal(syscall.SIGTERM)
+	}
+
+	deadline := time.Now().Add(getGrace())
+	for time.Now().Before(deadline) && !s.exited(proc) {
+		time.Sleep(terminatePoll)
+	}
+
+	if !s.exited(proc) {
+		_ = systemctl("kill", "--signal=SIGKILL", s.Unit)
+		_ = proc.Kill()
+	}
+	_ = systemctl("stop", s.Unit)
+
+	if pids := s.survivors(); len(pids) > 0 {
+		slog.Error("sandbox scope survived termination",
+			slog.String("unit", s.Unit),
+			slog.String("pids", strings.Join(pids, ",")),
+		)
+		return fmt.Errorf("scope %s still has processes: %s", s.Unit, strings.Join(pids, ","))
+	}
+	return nil
+}
+
+// * reaped by Wait, or scope cgroup already empty
+func (s *Scope) exited(proc *os.Process) bool {
+	if errors.Is(proc.Signal(syscall.Signal(0)), os.ErrProcessDone) {
+		return true
+	}
+
+	s.mu.Lock()
+	path := s.path
+	s.mu.Unlock()
+	if path == "" {
+		return false
+	}
+	b, err := os.ReadFile(filepath.Join(path, "cgroup.procs"))
+	return err != nil || strings.TrimSpace(string(b)) == ""
+}
+
+// * pids left in scope cgroup, systemd still reporting scope active counts as survivor
+func (s *Scope) survivors() []string {
+	s.mu.Lock()
+	path := s.path
+	s.mu.Unlock()
+
+	if path != "" {
+		b, err := os.ReadFile(filepath.Join(path, "cgroup.procs"))
+		if err != nil {
+			return nil
+		}
+		return strings.Fields(string(b))
+	}
+
+	if systemctl("is-active", "--quiet", s.Unit) == nil {
+		return []string{"unknown"}
+	}
+	return nil
+}
+
+func systemctl(args ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), systemctlTimeout)
+	defer cancel()
+
+	return exec.CommandContext(ctx, "systemctl", append([]string{"--user"}, args...)...).Run()
+}
@@ -0,0 +1,126 @@
+package scheduler
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/pardnchiu/go-faas/internal/database"
+	"github.com/robfig/cron/v3"
+)
+
+var (
+	parser = cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+)
+
+// * run stored function of schedule, return output and resolved version
+type Executor func(schedule *database.Schedule) (string, int64, error)
+
+func Validate(expr string) error {
+	_, err := parser.Parse(expr)
+	return err
+}
+
+func Start(exec Executor) {
+	var ctx context.Context
+	ctx, cancel = context.WithCancel(context.Background())
+
+	wg.Add(1)
+	go loop(ctx, exec)
+	slog.Info("scheduler started")
+}
+
+// * stop ticking and wait running schedules
+func Stop() {
+	if cancel == nil {
+		return
+	}
+	cancel()
+	wg.Wait()
+}
+
+func loop(ctx context.Context, exec Executor) {
+	defer wg.Done()
+
+	for {
+		// * wake at every minute boundary, cron resolution is one minute
+		next := time.Now().Truncate(time.Minute).Add(time.Minute)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(next)):
+		}
+		tick(ctx, next, exec)
+	}
+}
+
+func tick(ctx context.Context, at time.Time, exec Executor) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	list, err := database.DB.ListSchedules(ctx)
+	if err != nil {
+		slog.Error("failed to list schedules", slog.String("error", err.Error()))
+		return
+	}
+
+	for _, schedule := range list {
+		expr, err := parser.Parse(schedule.Cron)
+		if err != nil {
+			slog.Warn("invalid schedule", slog.String("id", schedule.ID), slog.String("error", err.Error()))
+			continue
+		}
+		if !expr.Next(at.Add(-time.Second)).Equal(at) {
+			continue
+		}
+
+		// * instances sharing one store race here, only the winner fires
+		ok, err := database.DB.ClaimSchedule(ctx, schedule.ID, at.Unix())
+		if err != nil {
+			slog.Error("failed to claim schedule", slog.String("id", schedule.ID), slog.String("error", err.Error()))
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		wg.Add(1)
+		go fire(schedule, at, exec)
+	}
+}
+
+func fire(schedule database.Schedule, at time.Time, exec Executor) {
+	defer wg.Done()
+
+	start := time.Now()
+	_, version, err := exec(&schedule)
+
+	run := database.ScheduleRun{
+		At:       at.Unix(),
+		Status:   "succeeded",
+		Version:  version,
+		Duration: time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		run.Status = "failed"
+		run.Error = err.Error()
+	}
+
+	slog.Info("schedule fired",
+		slog.String("id", schedule.ID),
+		slog.String("path", schedule.Path),
+		slog.Int64("version", version),
+		slog.String("status", run.Status),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := database.DB.SetScheduleRun(ctx, schedule.ID, run); err != nil {
+		slog.Error("failed to save schedule run", slog.String("id", schedule.ID), slog.String("error", err.Error()))
+	}
+}
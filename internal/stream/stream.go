@@ -0,0 +1,202 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pardnchiu/go-faas/internal/utils"
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	ErrStreamNotFound = errors.New("stream not found")
+
+	rdb       *redis.Client
+	streamTTL time.Duration
+
+	// * fallback buffer when store is not redis, single instance only
+	mu     sync.Mutex
+	memory = map[string]*memStream{}
+)
+
+// * numbered SSE event, id starts from 1
+type Event struct {
+	ID    int64
+	Event string
+	Data  string
+}
+
+type memStream struct {
+	path    string
+	events  []Event
+	done    bool
+	expires time.Time
+}
+
+func Start(client *redis.Client) {
+	rdb = client
+	streamTTL = time.Duration(utils.GetWithDefaultInt("STREAM_TTL_SECONDS", 300)) * time.Second
+	if rdb == nil {
+		slog.Warn("stream buffer in memory, resume works on this instance only")
+	}
+}
+
+// * record function path of stream before first event, checked when a follower resumes
+func Open(ctx context.Context, id, path string) error {
+	if rdb == nil {
+		mu.Lock()
+		defer mu.Unlock()
+
+		cleanup()
+		memory[id] = &memStream{path: path, expires: time.Now().Add(streamTTL)}
+		return nil
+	}
+
+	if err := rdb.Set(ctx, pathKey(id), path, streamTTL).Err(); err != nil {
+		return fmt.Errorf("failed to open stream: %w", err)
+	}
+	return nil
+}
+
+// * function path given to Open, empty for inline code
+func Path(ctx context.Context, id string) (string, error) {
+	if rdb == nil {
+		mu.Lock()
+		defer mu.Unlock()
+
+		cleanup()
+		s := memory[id]
+		if s == nil {
+			return "", ErrStreamNotFound
+		}
+		return s.path, nil
+	}
+
+	path, err := rdb.Get(ctx, pathKey(id)).Result()
+	if err == redis.Nil {
+		return "", ErrStreamNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get stream path: %w", err)
+	}
+	return path, nil
+}
+
+// * append event, return its id
+func Append(ctx context.Context, id, event, data string) (int64, error) {
+	if rdb == nil {
+		mu.Lock()
+		defer mu.Unlock()
+
+		cleanup()
+		s := memory[id]
+		if s == nil {
+			s = &memStream{}
+			memory[id] = s
+		}
+		s.events = append(s.events, Event{ID: int64(len(s.events) + 1), Event: event, Data: data})
+		s.expires = time.Now().Add(streamTTL)
+		return int64(len(s.events)), nil
+	}
+
+	key := eventsKey(id)
+	pipe := rdb.TxPipeline()
+	push := pipe.RPush(ctx, key, event+"\n"+data)
+	pipe.Expire(ctx, key, streamTTL)
+	pipe.Expire(ctx, pathKey(id), streamTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, fmt.Errorf("failed to append event: %w", err)
+	}
+	return push.Val(), nil
+}
+
+// * mark stream finished, followers stop after last event
+func Finish(ctx context.Context, id string) error {
+	if rdb == nil {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if s := memory[id]; s != nil {
+			s.done = true
+		}
+		return nil
+	}
+
+	if err := rdb.Set(ctx, doneKey(id), 1, streamTTL).Err(); err != nil {
+		return fmt.Errorf("failed to finish stream: %w", err)
+	}
+	return nil
+}
+
+// * events after given id, done is checked first so no event is missed
+func Read(ctx context.Context, id string, after int64) ([]Event, bool, error) {
+	if rdb == nil {
+		mu.Lock()
+		defer mu.Unlock()
+
+		cleanup()
+		s := memory[id]
+		if s == nil {
+			return nil, false, ErrStreamNotFound
+		}
+		if after >= int64(len(s.events)) {
+			return nil, s.done, nil
+		}
+		return append([]Event(nil), s.events[max(after, 0):]...), s.done, nil
+	}
+
+	pipe := rdb.Pipeline()
+	done := pipe.Exists(ctx, doneKey(id))
+	list := pipe.LRange(ctx, eventsKey(id), max(after, 0), -1)
+	exists := pipe.Exists(ctx, eventsKey(id))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, false, fmt.Errorf("failed to read events: %w", err)
+	}
+	if exists.Val() == 0 {
+		return nil, false, ErrStreamNotFound
+	}
+
+	events := make([]Event, 0, len(list.Val()))
+	for i, raw := range list.Val() {
+		event, data, _ := strings.Cut(raw, "\n")
+		events = append(events, Event{ID: max(after, 0) + int64(i) + 1, Event: event, Data: data})
+	}
+	return events, done.Val() > 0, nil
+}
+
+// * parse Last-Event-ID, invalid value replays from start
+func ParseID(raw string) int64 {
+	id, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || id < 0 {
+		return 0
+	}
+	return id
+}
+
+// * drop expired memory streams, caller holds mu
+func cleanup() {
+	now := time.Now()
+	for id, s := range memory {
+		if now.After(s.expires) {
+			delete(memory, id)
+		}
+	}
+}
+
+func eventsKey(id string) string {
+	return fmt.Sprintf("stream:%s", id)
+}
+
+func pathKey(id string) string {
+	return fmt.Sprintf("stream:%s:path", id)
+}
+
+func doneKey(id string) string {
+	return fmt.Sprintf("stream:%s:done", id)
+}
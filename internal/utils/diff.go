@@ -0,0 +1,247 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	diffContext = 3
+	// * time grows with lines times edit distance, keep it bounded
+	diffMaxLines = 10000
+)
+
+var ErrDiffTooLarge = errors.New("diff too large")
+
+type diffOp struct {
+	kind byte
+	line string
+	// * 0-based position in a and b before this op
+	a, b int
+}
+
+// * unified diff of two texts by line, empty string when identical
+func UnifiedDiff(fromName, toName, a, b string) (string, error) {
+	aLines, bLines := splitLines(a), splitLines(b)
+	if len(aLines)+len(bLines) > diffMaxLines {
+		return "", fmt.Errorf("%w: more than %d lines", ErrDiffTooLarge, diffMaxLines)
+	}
+	ops := diffLines(aLines, bLines)
+
+	var sb strings.Builder
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+
+		// * merge changes separated by no more than 2*context equal lines
+		end := i
+		for j := i; j < len(ops); j++ {
+			if ops[j].kind != ' ' {
+				end = j
+				continue
+			}
+			if j-end > 2*diffContext {
+				break
+			}
+		}
+		start := max(i-diffContext, 0)
+		stop := min(end+diffContext+1, len(ops))
+
+		if sb.Len() == 0 {
+			fmt.Fprintf(&sb, "--- %s\n+++ %s\n", fromName, toName)
+		}
+		writeHunk(&sb, ops[start:stop])
+		i = stop
+	}
+	return sb.String(), nil
+}
+
+func writeHunk(sb *strings.Builder, ops []diffOp) {
+	var aCount, bCount int
+	for _, op := range ops {
+		if op.kind != '+' {
+			aCount++
+		}
+		if op.kind != '-' {
+			bCount++
+		}
+	}
+
+	aStart := ops[0].a
+	if aCount > 0 {
+		aStart++
+	}
+	bStart := ops[0].b
+	if bCount > 0 {
+		bStart++
+	}
+
+	fmt.Fprintf(sb, "@@ -%d,%d +%d,%d @@\n", aStart, aCount, bStart, bCount)
+	for _, op := range ops {
+		sb.WriteByte(op.kind)
+		sb.WriteString(op.line)
+		sb.WriteByte('\n')
+	}
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// * linear space myers diff, split at the middle snake and recurse on both halves
+func diffLines(a, b []string) []diffOp {
+	// * equal lines share one id, comparisons stay cheap
+	ids := map[string]int{}
+	intern := func(lines []string) []int {
+		out := make([]int, len(lines))
+		for i, line := range lines {
+			id, ok := ids[line]
+			if !ok {
+				id = len(ids)
+				ids[line] = id
+			}
+			out[i] = id
+		}
+		return out
+	}
+
+	d := &differ{a: a, b: b, ai: intern(a), bi: intern(b)}
+	d.compare(0, len(a), 0, len(b))
+	return d.ops
+}
+
+type differ struct {
+	a, b   []string
+	ai, bi []int
+	ops    []diffOp
+}
+
+func (d *differ) compare(aLo, aHi, bLo, bHi int) {
+	for aLo < aHi && bLo < bHi && d.ai[aLo] == d.bi[bLo] {
+		d.ops = append(d.ops, diffOp{kind: ' ', line: d.a[aLo], a: aLo, b: bLo})
+		aLo++
+		bLo++
+	}
+	// * common suffix written after the changes
+	aEnd, bEnd := aHi, bHi
+	for aLo < aEnd && bLo < bEnd && d.ai[aEnd-1] == d.bi[bEnd-1] {
+		aEnd--
+		bEnd--
+	}
+
+	switch {
+	case aLo == aEnd:
+		for y := bLo; y < bEnd; y++ {
+			d.ops = append(d.ops, diffOp{kind: '+', line: d.b[y], a: aLo, b: y})
+		}
+	case bLo == bEnd:
+		for x := aLo; x < aEnd; x++ {
+			d.ops = append(d.ops, diffOp{kind: '-', line: d.a[x], a: x, b: bLo})
+		}
+	default:
+		if x, y, ok := d.middle(aLo, aEnd, bLo, bEnd); ok {
+			d.compare(aLo, x, bLo, y)
+			d.compare(x, aEnd, y, bEnd)
+			break
+		}
+		// * nothing in common
+		for x := aLo; x < aEnd; x++ {
+			d.ops = append(d.ops, diffOp{kind: '-', line: d.a[x], a: x, b: bLo})
+		}
+		for y := bLo; y < bEnd; y++ {
+			d.ops = append(d.ops, diffOp{kind: '+', line: d.b[y], a: aEnd, b: y})
+		}
+	}
+
+	for x, y := aEnd, bEnd; x < aHi; x, y = x+1, y+1 {
+		d.ops = append(d.ops, diffOp{kind: ' ', line: d.a[x], a: x, b: y})
+	}
+}
+
+// * forward and reverse search until paths overlap, only two V arrays are kept
+func (d *differ) middle(aLo, aHi, bLo, bHi int) (int, int, bool) {
+	a, b := d.ai[aLo:aHi], d.bi[bLo:bHi]
+	n, m := len(a), len(b)
+	maxD := (n + m + 1) / 2
+	offset := maxD
+	v1 := make([]int, 2*maxD+2)
+	v2 := make([]int, 2*maxD+2)
+	for i := range v1 {
+		v1[i], v2[i] = -1, -1
+	}
+	v1[offset+1], v2[offset+1] = 0, 0
+
+	delta := n - m
+	// * odd delta overlaps on the forward pass, even on the reverse one
+	front := delta%2 != 0
+	var k1Start, k1End, k2Start, k2End int
+
+	for step := 0; step < maxD; step++ {
+		for k1 := -step + k1Start; k1 <= step-k1End; k1 += 2 {
+			i := offset + k1
+			var x1 int
+			if k1 == -step || (k1 != step && v1[i-1] < v1[i+1]) {
+				x1 = v1[i+1]
+			} else {
+				x1 = v1[i-1] + 1
+			}
+			y1 := x1 - k1
+			for x1 < n && y1 < m && a[x1] == b[y1] {
+				x1++
+				y1++
+			}
+			v1[i] = x1
+
+			switch {
+			case x1 > n:
+				k1End += 2
+			case y1 > m:
+				k1Start += 2
+			case front:
+				j := offset + delta - k1
+				if j >= 0 && j < len(v2) && v2[j] != -1 && x1 >= n-v2[j] {
+					return aLo + x1, bLo + y1, true
+				}
+			}
+		}
+
+		for k2 := -step + k2Start; k2 <= step-k2End; k2 += 2 {
+			i := offset + k2
+			var x2 int
+			if k2 == -step || (k2 != step && v2[i-1] < v2[i+1]) {
+				x2 = v2[i+1]
+			} else {
+				x2 = v2[i-1] + 1
+			}
+			y2 := x2 - k2
+			for x2 < n && y2 < m && a[n-x2-1] == b[m-y2-1] {
+				x2++
+				y2++
+			}
+			v2[i] = x2
+
+			switch {
+			case x2 > n:
+				k2End += 2
+			case y2 > m:
+				k2Start += 2
+			case !front:
+				j := offset + delta - k2
+				if j >= 0 && j < len(v1) && v1[j] != -1 {
+					x1 := v1[j]
+					y1 := offset + x1 - j
+					if x1 >= n-x2 {
+						return aLo + x1, bLo + y1, true
+					}
+				}
+			}
+		}
+	}
+	return 0, 0, false
+}
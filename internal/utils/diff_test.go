@@ -0,0 +1,139 @@
+package utils
+
+import (
+	"errors"
+	"math/rand"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// * rebuild both sides from the ops and count edits
+func applyOps(t *testing.T, ops []diffOp) (a, b []string, edits int) {
+	t.Helper()
+	for _, op := range ops {
+		if op.a != len(a) || op.b != len(b) {
+			t.Fatalf("op %q at (%d,%d), want (%d,%d)", op.kind, op.a, op.b, len(a), len(b))
+		}
+		switch op.kind {
+		case ' ':
+			a = append(a, op.line)
+			b = append(b, op.line)
+		case '-':
+			a = append(a, op.line)
+			edits++
+		case '+':
+			b = append(b, op.line)
+			edits++
+		default:
+			t.Fatalf("unknown op %q", op.kind)
+		}
+	}
+	return a, b, edits
+}
+
+// * length of longest common subsequence, edits = n + m - 2 * lcs
+func lcs(a, b []string) int {
+	prev := make([]int, len(b)+1)
+	for i := range a {
+		cur := make([]int, len(b)+1)
+		for j := range b {
+			if a[i] == b[j] {
+				cur[j+1] = prev[j] + 1
+			} else {
+				cur[j+1] = max(prev[j+1], cur[j])
+			}
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+	}{
+		{"empty", "", ""},
+		{"insert all", "", "a b c"},
+		{"delete all", "a b c", ""},
+		{"equal", "a b c", "a b c"},
+		{"replace", "a b c", "x y z"},
+		{"middle", "a b c d e", "a x c y e"},
+		{"myers paper", "a b c a b b a", "c b a b a c"},
+		{"prefix suffix", "p q a b s t", "p q c s t"},
+		{"move", "a b c d", "c d a b"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := strings.Fields(tt.a), strings.Fields(tt.b)
+			gotA, gotB, edits := applyOps(t, diffLines(a, b))
+			if !slices.Equal(gotA, a) || !slices.Equal(gotB, b) {
+				t.Fatalf("ops rebuild %v / %v, want %v / %v", gotA, gotB, a, b)
+			}
+			if want := len(a) + len(b) - 2*lcs(a, b); edits != want {
+				t.Fatalf("edits = %d, want %d", edits, want)
+			}
+		})
+	}
+}
+
+func TestDiffLinesRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	gen := func() []string {
+		lines := make([]string, r.Intn(40))
+		for i := range lines {
+			lines[i] = strconv.Itoa(r.Intn(5))
+		}
+		return lines
+	}
+
+	for i := 0; i < 500; i++ {
+		a, b := gen(), gen()
+		gotA, gotB, edits := applyOps(t, diffLines(a, b))
+		if !slices.Equal(gotA, a) || !slices.Equal(gotB, b) {
+			t.Fatalf("ops rebuild %v / %v, want %v / %v", gotA, gotB, a, b)
+		}
+		if want := len(a) + len(b) - 2*lcs(a, b); edits != want {
+			t.Fatalf("%v -> %v: edits = %d, want %d", a, b, edits, want)
+		}
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	got, err := UnifiedDiff("a/f", "b/f", "1\n2\n3\n", "1\nx\n3\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "--- a/f\n+++ b/f\n@@ -1,3 +1,3 @@\n 1\n-2\n+x\n 3\n"
+	if got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	if got, _ := UnifiedDiff("a/f", "b/f", "same\n", "same\n"); got != "" {
+		t.Fatalf("identical texts got %q", got)
+	}
+}
+
+func TestUnifiedDiffTooLarge(t *testing.T) {
+	text := strings.Repeat("line\n", diffMaxLines/2+1)
+	if _, err := UnifiedDiff("a/f", "b/f", text, text); !errors.Is(err, ErrDiffTooLarge) {
+		t.Fatalf("err = %v, want ErrDiffTooLarge", err)
+	}
+}
+
+// * completely different sides at the line cap, memory stays linear
+func TestDiffLinesDisjoint(t *testing.T) {
+	n := diffMaxLines / 2
+	a, b := make([]string, n), make([]string, n)
+	for i := range a {
+		a[i] = "a" + strconv.Itoa(i)
+		b[i] = "b" + strconv.Itoa(i)
+	}
+	_, _, edits := applyOps(t, diffLines(a, b))
+	if edits != 2*n {
+		t.Fatalf("edits = %d, want %d", edits, 2*n)
+	}
+}
@@ -0,0 +1,36 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// * parse systemd style size, e.g. 512K, 128M, 1G, base 1024
+func ParseSize(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, fmt.Errorf("empty size")
+	}
+
+	unit := int64(1)
+	switch strings.ToUpper(value[len(value)-1:]) {
+	case "K":
+		unit = 1 << 10
+	case "M":
+		unit = 1 << 20
+	case "G":
+		unit = 1 << 30
+	case "T":
+		unit = 1 << 40
+	}
+	if unit > 1 {
+		value = value[:len(value)-1]
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size: %s", value)
+	}
+	return n * unit, nil
+}